@@ -7,7 +7,6 @@ require (
 	github.com/mattn/go-shellwords v1.0.12
 	github.com/pkg/xattr v0.4.10
 	github.com/spf13/cobra v1.8.1
-	github.com/thediveo/enumflag/v2 v2.0.5
 )
 
 require (
@@ -15,7 +14,6 @@ require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/exp v0.0.0-20231006140011-7918f672742d // indirect
 	golang.org/x/sys v0.13.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -9,9 +9,25 @@ var CacheCmd = &cobra.Command{
 When desktop or mimeapps.list files are changed, either from the user manually changing it, or as
 a result of the installation of a program, this cache can become out-of-date.
 
-To update the cache, use "opn cache update".`,
+The cache records the state of the application directories and mimeapps.list files it was
+generated from. When any of these change, e.g. by installing a program, the affected parts of the
+cache are regenerated automatically. Desktop files that are modified in place are not detected.
+To cover this, the cache is regenerated completely once it is older than OPN_CACHE_MAX_AGE.
+
+To update the cache manually, use "opn cache update".
+To view information about the cache, use "opn cache info".
+To export the cache as JSON, use "opn cache export".
+To check the desktop and mimeapps.list files for problems, use "opn cache doctor".
+
+ENVIRONMENT:
+  OPN_CACHE_MAX_AGE
+    The maximum age of the cache as a Go duration, e.g. "24h" (the default) or "30m".
+    A negative value disables the age check.`,
 }
 
 func init() {
+	CacheCmd.AddCommand(doctorCacheCmd)
+	CacheCmd.AddCommand(exportCacheCmd)
+	CacheCmd.AddCommand(infoCacheCmd)
 	CacheCmd.AddCommand(updateCacheCmd)
 }
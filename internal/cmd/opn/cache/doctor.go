@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/MatthiasKunnen/opn/pkg/opnlib"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"slices"
+)
+
+var doctorJson bool
+
+var doctorCacheCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks the desktop files and mimeapps.list files for problems",
+	Long: `Scans all desktop files and mimeapps.list files that are used to generate the
+cache and reports the problems that are found.
+
+The following is checked:
+  - Desktop files and mimeapps.list files that cannot be parsed.
+  - Lines of mimeapps.list files that are ignored because they are malformed
+    or part of an unknown group.
+  - Exec programs that cannot be found in PATH.
+  - TryExec programs that cannot be found, which causes the application to be
+    ignored.
+  - Associations in mimeapps.list files with desktop IDs that do not exist.
+  - Desktop files that are shadowed by a desktop file with the same desktop ID
+    in a directory of higher priority.
+  - MIME types that are not in the shared-mime-info database.
+  - Applications associated with MIME types whose Exec value has no field code
+    to pass files or URLs.
+
+Every problem has a severity of error, warning, or info. The exit code is 1 if
+any problem with the error severity is found.`,
+	Example: `$ opn cache doctor
+$ opn cache doctor --json | jq '.[] | select(.Severity == "error")'`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		problems, err := opnlib.Doctor()
+		if err != nil {
+			log.Fatalf("Failed to check: %v", err)
+		}
+
+		if doctorJson {
+			err = json.NewEncoder(os.Stdout).Encode(problems)
+			if err != nil {
+				log.Fatalf("Failed to encode JSON: %v", err)
+			}
+		} else {
+			for _, problem := range problems {
+				fmt.Printf("%s: [%s] ", problem.Severity, problem.Check)
+				if problem.Path != "" {
+					fmt.Printf("%s: ", problem.Path)
+				}
+				fmt.Println(problem.Message)
+			}
+
+			if len(problems) == 0 {
+				fmt.Println("No problems found.")
+			}
+		}
+
+		hasErrors := slices.ContainsFunc(problems, func(p opnlib.DoctorProblem) bool {
+			return p.Severity == opnlib.DoctorError
+		})
+		if hasErrors {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	doctorCacheCmd.Flags().BoolVar(
+		&doctorJson,
+		"json",
+		false,
+		"Output the problems as a JSON array.",
+	)
+}
@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"errors"
+	"github.com/MatthiasKunnen/opn/pkg/opnlib"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var exportCacheCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Writes the index to stdout as JSON",
+	Long: `Loads the index, updating it if necessary, and writes it to stdout as JSON.
+The structure of the JSON is documented in the Index type of the opnlib package.`,
+	Example: `$ opn cache export > db.json`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		opn := &opnlib.Opn{}
+		err := opn.LoadAndSave()
+		switch {
+		case errors.Is(err, opnlib.FailedToSaveCache):
+			log.Printf("%v\n", err)
+		case err != nil:
+			log.Fatalf("Failed to load: %v", err)
+		}
+
+		err = opn.ExportJson(os.Stdout)
+		if err != nil {
+			log.Fatalf("Failed to encode JSON: %v", err)
+		}
+	},
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/opn/pkg/opnlib"
+	"github.com/spf13/cobra"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+)
+
+var infoCacheCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Shows information about the cache",
+	Long: `Shows the location, size, version, and generation time of the cache together with
+the directories and mimeapps.list files it was generated from and the amount of MIME
+types and desktop IDs it contains.
+
+The cache is read as-is, it is not updated.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cachePath := (&opnlib.Opn{}).GetCachePath()
+		fmt.Printf("Path: %s\n", cachePath)
+
+		stat, err := os.Stat(cachePath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			fmt.Println("The cache does not exist, it will be generated when needed.")
+			return
+		case err != nil:
+			log.Fatalf("Failed to stat cache: %v", err)
+		}
+		fmt.Printf("Size: %d bytes\n", stat.Size())
+
+		index, err := opnlib.LoadIndex(cachePath)
+		switch {
+		case errors.Is(err, opnlib.ErrIndexVersionMismatch):
+			fmt.Printf("%v\nThe cache will be regenerated when needed.\n", err)
+			return
+		case err != nil:
+			log.Fatalf("Failed to load cache: %v", err)
+		}
+
+		fmt.Printf("Version: %d\n", index.Version)
+		fmt.Printf("Generated on: %s\n", index.GeneratedOn.Format(time.RFC3339))
+		fmt.Printf("MIME types: %d\n", index.MimeTypeCount())
+		fmt.Printf("Desktop IDs: %d\n", index.DesktopIdCount())
+
+		fmt.Println("Application directories:")
+		for _, source := range index.DesktopDirs {
+			if source.Exists {
+				fmt.Printf("  %s\n", source.Path)
+			}
+		}
+
+		fmt.Println("mimeapps.list files:")
+		for _, source := range index.MimeappsLists {
+			if source.Exists {
+				fmt.Printf("  %s\n", source.Path)
+			}
+		}
+	},
+}
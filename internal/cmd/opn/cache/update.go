@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"errors"
 	"github.com/MatthiasKunnen/opn/pkg/opnlib"
 	"github.com/spf13/cobra"
 	"log"
@@ -9,18 +10,22 @@ import (
 var updateCacheCmd = &cobra.Command{
 	Use:   "update",
 	Short: "Updates the index that is used to look up MIME/application association",
-	Args:  cobra.NoArgs,
+	Long: `Generates the index from the file system and saves it to the cache.
+If opn daemon is running, the daemon regenerates its index and saves the cache
+instead.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		opn := &opnlib.Opn{
-			SkipCache: true,
-		}
-		err := opn.Load()
-		if err != nil {
+		opn := &opnlib.Opn{}
+		err := opn.Regenerate()
+		switch {
+		case errors.Is(err, opnlib.FailedToSaveCache):
+			log.Fatalf("Failed to save index: %v", err)
+		case err != nil:
 			log.Fatalf("Failed generate index: %v", err)
 		}
-		err = opn.SaveIndex()
-		if err != nil {
-			log.Fatalf("Failed to save index: %v", err)
+
+		for _, entryError := range opn.GetDesktopEntryErrors() {
+			log.Printf("Warning: failed to parse %s: %s\n", entryError.Path, entryError.Error)
 		}
 
 		println("Cache successfully updated.")
@@ -0,0 +1,44 @@
+package opn
+
+import (
+	"context"
+	"github.com/MatthiasKunnen/opn/pkg/opnlib"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keeps the index in memory and serves lookup and open requests of opn processes",
+	Long: `Loads the index once and keeps it up-to-date by watching the application
+directories, the mimeapps.list files, and the shared-mime-info database using
+inotify. Only the parts of the index that are affected by a change are
+regenerated. The cache is kept up-to-date as well.
+
+While the daemon is running, other opn processes perform their lookups using
+the daemon instead of loading the cache. Applications that are opened detached
+are started by the daemon, using the environment and working directory of the
+opn process that was invoked. They are therefore not stopped when the terminal
+that opn was invoked in closes. Applications that run in the terminal of opn
+are still started by opn itself.
+"opn cache update" makes the daemon regenerate its index.
+The daemon is only used by processes that have the same XDG environment
+variables and cache path as the daemon. When no daemon is running, or it
+cannot be used, opn behaves as it does without daemon.
+
+The daemon listens on $XDG_RUNTIME_DIR/opn/daemon.sock.`,
+	Example: `opn daemon`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		err := (&opnlib.Daemon{}).Run(ctx)
+		if err != nil {
+			log.Fatalf("Daemon failed: %v", err)
+		}
+	},
+}
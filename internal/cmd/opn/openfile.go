@@ -7,6 +7,10 @@ import (
 
 var mime string
 var skipCache bool
+var showAll bool
+var showDetails bool
+var workingDir string
+var dryRun bool
 
 var openFileCmd = &cobra.Command{
 	Use:   "file <filename>",
@@ -21,13 +25,21 @@ specification.
 The MIME type is determined in this order:
 1. The value specified using the --mime-type option.
 2. The value of the extended file attribute user.mime, if it exists.
-3. The value reported by the relevant utility, xdg-query or file.`,
+3. The value reported by the relevant utility, xdg-mime or file.
+4. The shared-mime-info glob matching the file name.
+5. The MIME type sniffed from the file's content.
+
+Use "opn query file" to see the result of every stage.`,
 	Example: `opn file foo.pdf`,
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		opn.File(args[0], opn.OpenerOpts{
 			MimeOverride: mime,
 			SkipCache:    skipCache,
+			ShowAll:      showAll,
+			ShowDetails:  showDetails,
+			WorkingDir:   workingDir,
+			DryRun:       dryRun,
 		})
 	},
 }
@@ -41,10 +53,36 @@ ATTACHING TO TERMINAL:
   Interactively, when choosing the application, optionally append the start mode to the index:
     a attached, the application will be opened in the current terminal.
     d detached. GUI application will be detached, terminal applications will be opened in
-      a new terminal based on 'OPN_TERM_CMD'.
+      a new terminal, see CHOOSING A TERMINAL.
+    w window, the application will be opened in a new window of the tmux, kitty, or wezterm
+      session opn is running in.
+    p pane, the application will be opened in a new pane of the tmux, zellij, kitty, or
+      wezterm session opn is running in.
   For example, 3h will launch the application with index 3 in the current terminal.
   If no start mode is specified, 'OPN_START_MODE' is used to determine the default.
 
+CHOOSING A TERMINAL:
+  The terminal to open terminal applications in is the first one found of:
+  1. The command in 'OPN_TERM_CMD' or 'TERMINAL_COMMAND'.
+  2. The first installed terminal listed in the xdg-terminals.list files of the proposed
+     xdg-terminal-exec spec, e.g. ~/.config/xdg-terminals.list containing foot.desktop.
+     The X-TerminalArg* keys of the terminal's desktop file are respected.
+  3. The first installed terminal of: foot, kitty, alacritty, wezterm, gnome-terminal, konsole,
+     and xterm.
+  Except for the first option, the title, app ID, and working directory of the terminal are set
+  if the terminal supports it.
+
+LAUNCH CONFIGURATION:
+  How an application is started can be changed per desktop ID in
+  $XDG_CONFIG_HOME/opn/launch.conf. E.g.:
+    [firefox.desktop]
+    Args=--new-window
+    Env=GDK_BACKEND=x11;MOZ_ENABLE_WAYLAND=0
+    UnsetEnv=WAYLAND_DISPLAY
+    Wrapper=firejail --noprofile
+  Args are inserted before the file or URL, Wrapper is prepended to the command. Env and
+  UnsetEnv are semicolon separated lists. Use --dry-run to see the resulting command.
+
 ENVIRONMENT:
   OPN_START_MODE
     Configures where to open applications.
@@ -52,13 +90,46 @@ ENVIRONMENT:
       OPN_START_MODE="gui:d,term:a", the default, GUI applications are detached and terminal
         applications will be opened in the current terminal.
       OPN_START_MODE="gui:d,term:d", always detach.
+      OPN_START_MODE="gui:d,term:p", open terminal applications in a new pane when running in
+        a multiplexer, detach them otherwise.
+    GUI applications cannot be opened in a window or pane, only a and d are accepted for gui.
     The start mode can be overwritten by appending it to the application's index.
+  OPN_ATTACH_MODE
+    Configures how applications are opened in the current terminal.
+      exec, the default, opn is replaced by the application.
+      child, the application is started as a child process of opn.
+    A child process is always used for downloaded files so that they can be removed
+    after the application exits.
+  OPN_LAUNCHER
+    Configures how detached applications are started.
+      direct, the default, the application is started in a new session.
+      systemd-scope, the application is started in a transient systemd user scope
+        using systemd-run. This places it in its own cgroup, separate from the terminal.
+      systemd-service, the application is started as a transient systemd user
+        service. It inherits the environment of the systemd user manager.
+  OPN_LAUNCH_CONFIG
+    The path of the launch configuration file, see LAUNCH CONFIGURATION.
+  OPN_TERMINAL_CLOSES
+    Whether the terminal opn runs in closes when opn exits, e.g. when started using
+    "foot opn file x". If so, opn waits for terminal applications opened in a new terminal to
+    start before exiting.
+      auto, the default, detect this using the processes in the session of opn.
+      always, always wait.
+      never, never wait.
+  OPN_ACTIVATION_TOKEN_CMD
+    A command that prints an activation token for detached applications that support
+    startup notification. Used when opn itself was not started with a token in
+    XDG_ACTIVATION_TOKEN or DESKTOP_STARTUP_ID. It receives OPN_DESKTOP_ID and
+    OPN_STARTUP_WM_CLASS.
   OPN_TERM_CMD
-    The command to use when starting an application that has Terminal=true.
-    The arguments will be appended to this command.
+    The command to use when starting an application that has Terminal=true in a new terminal.
+    The arguments will be appended to this command. Takes precedence over xdg-terminals.list.
     E.g. "foot", "gnome-terminal --".
   TERMINAL_COMMAND
     Lower priority alias for OPN_TERM_CMD.
+  LC_ALL, LC_MESSAGES, LANG
+    The first one that is set determines the language of the application names,
+    e.g. "nl_BE.UTF-8".
 `
 
 func init() {
@@ -69,6 +140,32 @@ func init() {
 		false,
 		"Do not use the cache. Instead, all lookups are performed on the file system.",
 	)
+	openFileCmd.Flags().StringVar(
+		&workingDir,
+		"cwd",
+		"",
+		"Start the application in this directory instead of the one from its desktop file.",
+	)
+	openFileCmd.Flags().BoolVar(
+		&dryRun,
+		"dry-run",
+		false,
+		"Print the command, working directory, and environment instead of starting the "+
+			"application. Placeholders are printed instead of downloading the file and obtaining "+
+			"an activation token.",
+	)
+	openFileCmd.Flags().BoolVar(
+		&showDetails,
+		"details",
+		false,
+		"Show the generic name and comment of the applications.",
+	)
+	openFileCmd.Flags().BoolVar(
+		&showAll,
+		"show-all",
+		false,
+		"Also show applications hidden by NoDisplay, Hidden, TryExec, OnlyShowIn, or NotShowIn.",
+	)
 	openFileCmd.Flags().StringVar(
 		&mime,
 		"mime-type",
@@ -26,6 +26,10 @@ $ opn resource https://example.com`,
 		opn.FileOrUrl(args[0], opn.OpenerOpts{
 			MimeOverride: mime,
 			SkipCache:    skipCache,
+			ShowAll:      showAll,
+			ShowDetails:  showDetails,
+			WorkingDir:   workingDir,
+			DryRun:       dryRun,
 		})
 	},
 }
@@ -38,6 +42,32 @@ func init() {
 		false,
 		"Do not use the cache. Instead, all lookups are performed on the file system.",
 	)
+	openResourceCmd.Flags().StringVar(
+		&workingDir,
+		"cwd",
+		"",
+		"Start the application in this directory instead of the one from its desktop file.",
+	)
+	openResourceCmd.Flags().BoolVar(
+		&dryRun,
+		"dry-run",
+		false,
+		"Print the command, working directory, and environment instead of starting the "+
+			"application. Placeholders are printed instead of downloading the file and obtaining "+
+			"an activation token.",
+	)
+	openResourceCmd.Flags().BoolVar(
+		&showDetails,
+		"details",
+		false,
+		"Show the generic name and comment of the applications.",
+	)
+	openResourceCmd.Flags().BoolVar(
+		&showAll,
+		"show-all",
+		false,
+		"Also show applications hidden by NoDisplay, Hidden, TryExec, OnlyShowIn, or NotShowIn.",
+	)
 	openResourceCmd.Flags().StringVar(
 		&mime,
 		"mime-type",
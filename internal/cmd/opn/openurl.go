@@ -33,6 +33,10 @@ opening that MIME type.
 		opn.Url(args[0], opn.OpenerOpts{
 			MimeOverride: mime,
 			SkipCache:    skipCache,
+			ShowAll:      showAll,
+			ShowDetails:  showDetails,
+			WorkingDir:   workingDir,
+			DryRun:       dryRun,
 		})
 	},
 }
@@ -45,6 +49,32 @@ func init() {
 		false,
 		"Do not use the cache. Instead, all lookups are performed on the file system.",
 	)
+	openUrlCmd.Flags().StringVar(
+		&workingDir,
+		"cwd",
+		"",
+		"Start the application in this directory instead of the one from its desktop file.",
+	)
+	openUrlCmd.Flags().BoolVar(
+		&dryRun,
+		"dry-run",
+		false,
+		"Print the command, working directory, and environment instead of starting the "+
+			"application. Placeholders are printed instead of downloading the file and obtaining "+
+			"an activation token.",
+	)
+	openUrlCmd.Flags().BoolVar(
+		&showDetails,
+		"details",
+		false,
+		"Show the generic name and comment of the applications.",
+	)
+	openUrlCmd.Flags().BoolVar(
+		&showAll,
+		"show-all",
+		false,
+		"Also show applications hidden by NoDisplay, Hidden, TryExec, OnlyShowIn, or NotShowIn.",
+	)
 	openUrlCmd.Flags().StringVar(
 		&mime,
 		"mime-type",
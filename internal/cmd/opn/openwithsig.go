@@ -1,6 +1,7 @@
 package opn
 
 import (
+	"github.com/MatthiasKunnen/opn/internal/opn"
 	"github.com/spf13/cobra"
 	"log"
 	"os"
@@ -8,8 +9,10 @@ import (
 	"strings"
 )
 
+var openWithSignalWorkingDir string
+
 var openWithSignalCmd = &cobra.Command{
-	Use:    "openwithsig fifo_path cmd_args...",
+	Use:    "openwithsig [--cwd dir] fifo_path cmd_args...",
 	Short:  "Executed the given command and send a signal when done",
 	Hidden: true,
 	Long: `This internal command only exists because of timing issues that can cause programs
@@ -38,6 +41,9 @@ detected, the following is done:
 5. After the command is started, opn two will write to the FIFO, signaling that the process has
    started and opn one will exit after cleaning up.
    This should make sure that the launched program had time to establish itself.
+   If the command fails to start, opn two writes the error to the FIFO instead, which opn one
+   prints. opn one stops waiting if no signal arrives within 10 seconds.
+The FIFO is created in a private directory under $XDG_RUNTIME_DIR.
 
 The only downside is that as long as the launched terminal program stays running, opn two will keep
 running and using a certain amount of memory.
@@ -46,35 +52,50 @@ running and using a certain amount of memory.
 	Args:    cobra.MinimumNArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		fifoPath := args[0]
-		fifo, err := os.OpenFile(fifoPath, os.O_WRONLY, 0600)
+		fifo, err := opn.OpenStartSignalWriter(fifoPath)
 		if err != nil {
-			log.Fatalf("Failed to open fifo at %s: %v\n", fifoPath, err)
+			// The program is still started, opn merely stopped waiting for it
+			log.Printf("Failed to open fifo at %s: %v\n", fifoPath, err)
+		} else {
+			defer fifo.Close()
 		}
-		defer os.Remove(fifoPath)
-		defer fifo.Close()
 
 		arguments := args[1:]
 		eCmd := exec.Command(arguments[0], arguments[1:]...)
+		eCmd.Dir = openWithSignalWorkingDir
 		eCmd.Stdin = os.Stdin
 		eCmd.Stdout = os.Stdout
 		eCmd.Stderr = os.Stderr
-		err = eCmd.Start()
-		if err != nil {
+		startErr := eCmd.Start()
+		if fifo != nil {
+			err = opn.SendStartSignal(fifo, startErr)
+			if err != nil {
+				log.Printf("Error writing to FIFO: %v\n", err)
+			}
+		}
+
+		if startErr != nil {
 			log.Fatalf(
 				"Error running command '%s': %v\n",
 				strings.Join(arguments, " "),
-				err,
+				startErr,
 			)
 		}
 
-		_, err = fifo.Write([]byte("1")) // Signal that the program has started
-		if err != nil {
-			log.Printf("Error writing to FIFO: %v\n", err)
-		}
-
 		err = eCmd.Wait()
 		if err != nil {
 			log.Fatalf("Error waiting for command to finish: %v\n", err)
 		}
 	},
 }
+
+func init() {
+	// The flags of the command to run must not be parsed
+	openWithSignalCmd.Flags().SetInterspersed(false)
+	openWithSignalCmd.Flags().StringVar(
+		&openWithSignalWorkingDir,
+		"cwd",
+		"",
+		"The working directory to run the command in.",
+	)
+}
@@ -0,0 +1,143 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/opn/pkg/opnlib"
+	"github.com/spf13/cobra"
+	"log"
+	"strings"
+)
+
+type appAction struct {
+	Name string
+	Exec string
+}
+
+type appInfo struct {
+	DesktopId  string
+	FilePath   string
+	Name       string
+	Exec       string
+	Terminal   bool
+	NoDisplay  bool
+	Actions    []appAction
+	MimeTypes  []string
+	DefaultFor []string
+}
+
+var appsCmd = &cobra.Command{
+	Use:   "apps [desktop ID]",
+	Short: "Queries the installed applications and the MIME types they support",
+	Long: `Lists all installed applications, or only the given one, together with the
+MIME types they declare support for and the MIME types for which they are the
+default application.
+
+The information is taken from the highest priority desktop file of each
+desktop ID that can be parsed.
+
+The template output format is executed for every application.
+Template fields:
+  .DesktopId   The desktop ID.
+  .FilePath    The path of the desktop file.
+  .Name        The name of the application.
+  .Exec        The Exec value.
+  .Terminal    Whether the application runs in a terminal.
+  .NoDisplay   Whether the application is hidden from menus.
+  .Actions     The actions, each with the fields .Name and .Exec.
+  .MimeTypes   The MIME types of the MimeType key.
+  .DefaultFor  The MIME types the application is the default application of.`,
+	Example: `List all applications:
+$ opn query apps
+
+Show a single application:
+$ opn query apps vim.desktop`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		opn := mustLoadOpn()
+
+		var desktopIds []string
+		if len(args) == 0 {
+			desktopIds = opn.GetDesktopIds()
+		} else {
+			desktopIds = []string{resolveDesktopId(opn, args[0])}
+		}
+
+		result := make([]appInfo, 0, len(desktopIds))
+		for _, desktopId := range desktopIds {
+			app, ok := getAppInfo(opn, desktopId)
+			if ok {
+				result = append(result, app)
+			}
+		}
+
+		if len(args) > 0 && len(result) == 0 {
+			log.Fatalf("No application found with desktop ID %s", args[0])
+		}
+
+		printResult(result, func() {
+			for _, app := range result {
+				printAppInfo(app)
+			}
+		}, result, func(app appInfo) string {
+			return app.DesktopId
+		})
+	},
+}
+
+// getAppInfo returns the information of the first valid desktop file of the desktop ID. It
+// returns false if no desktop file could be parsed.
+func getAppInfo(opn *opnlib.Opn, desktopId string) (appInfo, bool) {
+	entry, err := opn.GetDesktopEntry(desktopId)
+	switch {
+	case errors.Is(err, opnlib.ErrBrokenDesktopEntry):
+		log.Printf("%v, see opn cache update for details\n", err)
+		return appInfo{}, false
+	case err != nil:
+		log.Printf("%v\n", err)
+		return appInfo{}, false
+	}
+
+	actions := make([]appAction, 0, len(entry.Actions))
+	for _, action := range entry.Actions {
+		actions = append(actions, appAction{
+			Name: action.Name.Default,
+			Exec: string(action.Exec),
+		})
+	}
+
+	mimeTypes := entry.MimeType
+	if mimeTypes == nil {
+		mimeTypes = make([]string, 0)
+	}
+
+	return appInfo{
+		DesktopId:  desktopId,
+		FilePath:   entry.FilePath,
+		Name:       entry.Name.Default,
+		Exec:       string(entry.Exec),
+		Terminal:   entry.Terminal,
+		NoDisplay:  entry.NoDisplay,
+		Actions:    actions,
+		MimeTypes:  mimeTypes,
+		DefaultFor: opn.GetMimesWithDefault(desktopId),
+	}, true
+}
+
+func printAppInfo(app appInfo) {
+	fmt.Printf("%s (%s)\n", app.DesktopId, app.FilePath)
+	fmt.Printf("  Name: %s\n", app.Name)
+	fmt.Printf("  Exec: %s\n", app.Exec)
+	fmt.Printf("  Terminal: %t\n", app.Terminal)
+	fmt.Printf("  NoDisplay: %t\n", app.NoDisplay)
+
+	if len(app.Actions) > 0 {
+		fmt.Println("  Actions:")
+		for _, action := range app.Actions {
+			fmt.Printf("    %s: %s\n", action.Name, action.Exec)
+		}
+	}
+
+	fmt.Printf("  MimeType: %s\n", strings.Join(app.MimeTypes, ", "))
+	fmt.Printf("  Default for: %s\n", strings.Join(app.DefaultFor, ", "))
+}
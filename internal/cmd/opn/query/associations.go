@@ -0,0 +1,49 @@
+package query
+
+import (
+	"fmt"
+	"github.com/MatthiasKunnen/opn/pkg/opnlib"
+	"github.com/spf13/cobra"
+)
+
+var associationsCmd = &cobra.Command{
+	Use:   "associations <desktop ID>",
+	Short: "Queries the MIME types a desktop ID is associated with",
+	Long: `Returns all MIME types the given desktop ID is associated with, after merging all
+mimeapps.list files and desktop files.
+
+For every MIME type, the rank of the desktop ID is shown, 0 being the default
+application, together with the mimeapps.list file and section that caused the
+association. If no mimeapps.list file mentions the desktop ID for the MIME
+type, the association comes from the MimeType key of the desktop file.
+
+This is useful when debugging why a file opens in a certain application.
+
+The template output format is executed for every MIME type.
+Template fields:
+  .Mime     The MIME type.
+  .Rank     The rank of the desktop ID, 0 being the default application.
+  .File     The mimeapps.list or desktop file that caused the association.
+  .Section  The mimeapps.list section, or MimeType for the desktop file.`,
+	Example: `$ opn query associations org.gnome.Evince.desktop`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		opn := mustLoadOpn()
+
+		desktopId := resolveDesktopId(opn, args[0])
+
+		result := opn.GetAssociationsOfDesktopId(desktopId)
+
+		printResult(result, func() {
+			if len(result) == 0 {
+				fmt.Printf("%s: No associated MIME types\n", desktopId)
+			}
+
+			for _, item := range result {
+				fmt.Printf("%s: rank %d, %s [%s]\n", item.Mime, item.Rank, item.File, item.Section)
+			}
+		}, result, func(item opnlib.AssociationSource) string {
+			return item.Mime
+		})
+	},
+}
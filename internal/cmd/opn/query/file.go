@@ -1,31 +1,86 @@
 package query
 
 import (
+	"fmt"
 	"github.com/MatthiasKunnen/opn/pkg/opnlib"
-	"github.com/pkg/xattr"
 	"github.com/spf13/cobra"
 	"log"
 )
 
+var fileMime string
+
 var fileCmd = &cobra.Command{
 	Use:   "file </path/to/file>",
 	Short: "Queries the applications that can open a file",
-	Args:  cobra.ExactArgs(1),
+	Long: `Determines the MIME type of the file and returns the desktop IDs of the
+applications associated with it.
+
+The MIME type is determined in the same way as opn file does, the first of
+these stages to produce a MIME type wins:
+1. The value specified using the --mime-type option.
+2. The value of the extended file attribute user.mime, if it exists.
+3. The value reported by xdg-mime.
+4. The value reported by file.
+5. The shared-mime-info glob matching the file name.
+6. The MIME type sniffed from the file's content.
+
+The verbose output includes the result of every stage.
+
+The template output format is executed for every MIME type and desktop ID pair.
+Template fields:
+  .Mime       The MIME type.
+  .DesktopId  The desktop ID associated with the MIME type.`,
+	Example: `$ opn query file foo.pdf`,
+	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		filePath := args[0]
-		var mime string
-		var err error
-		// If not overriden by --mime-type, try to get extended file attribute
-		var attrMime []byte
-		if attrMime, err = xattr.Get(filePath, "user.mime"); err == nil {
-			mime = string(attrMime)
-		} else {
-			mime, err = opnlib.GetFileMime(filePath)
-			if err != nil {
-				log.Fatalf("Failed to get MIME type of file %s: %v\n", filePath, err)
-			}
+		opn := mustLoadOpn()
+		resolution, err := opnlib.MimeResolver{
+			Override:  fileMime,
+			TryAll:    format.mode == outputVerbose,
+			MatchGlob: opn.MatchMimeGlob,
+		}.Resolve(filePath)
+		if err != nil {
+			log.Fatalf("Failed to get MIME type of file %s: %v\n", filePath, err)
 		}
 
-		queryMime(mime)
+		if format.mode == outputVerbose {
+			printMimeResolution(resolution)
+		}
+
+		queryMime(opn, resolution.Mime)
 	},
 }
+
+func printMimeResolution(resolution opnlib.MimeResolution) {
+	fmt.Println("MIME type detection:")
+	for _, stage := range resolution.Stages {
+		var value string
+		switch {
+		case stage.Skipped:
+			value = "skipped"
+		case stage.Error != "":
+			value = "error: " + stage.Error
+		case stage.Mime == "":
+			value = "no result"
+		default:
+			value = stage.Mime
+		}
+
+		if stage.Stage == resolution.Stage {
+			value += " (used)"
+		}
+
+		fmt.Printf("  %s: %s\n", stage.Stage, value)
+	}
+	fmt.Println()
+}
+
+func init() {
+	fileCmd.Flags().StringVar(
+		&fileMime,
+		"mime-type",
+		"",
+		"Set the mime type of the file and skip automatic determination.",
+	)
+}
@@ -1,45 +1,83 @@
 package query
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/MatthiasKunnen/opn/pkg/opnlib"
 	"github.com/spf13/cobra"
 	"log"
-	"os"
 	"strings"
 )
 
 var mimeCmd = &cobra.Command{
-	Use:     "mime <MimeType>",
-	Short:   "Queries the applications associated with a MIME type",
-	Long:    `Returns the desktop IDs of the applications associated with the given mime type.`,
-	Args:    cobra.ExactArgs(1),
-	Example: `$ opn query mime application/pdf`,
+	Use:   "mime <MimeType>",
+	Short: "Queries the applications associated with a MIME type",
+	Long: `Returns the desktop IDs of the applications associated with the given mime type.
+
+The MIME type is case-insensitive and aliases, such as application/x-pdf, are
+resolved to their canonical MIME type.
+The MIME type can also be a pattern such as image/*. The pattern is expanded
+over all MIME types known to the index and the results are grouped per MIME
+type. Broader MIME types are not included for patterns.
+
+The template output format is executed for every MIME type and desktop ID pair.
+Template fields:
+  .Mime       The MIME type.
+  .DesktopId  The desktop ID associated with the MIME type.`,
+	Args: cobra.ExactArgs(1),
+	Example: `$ opn query mime application/pdf
+
+All applications associated with any image type:
+$ opn query mime 'image/*'`,
 	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		return nil, cobra.ShellCompDirectiveNoFileComp
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		queryMime(args[0])
+		queryMime(mustLoadOpn(), args[0])
 	},
 }
 
-func queryMime(mimeType string) {
-	opn := &opnlib.Opn{
-		SkipCache: skipCache,
+// mimeDesktopId is a single MIME type/desktop ID pair used for the lines, nul, and template
+// output formats.
+type mimeDesktopId struct {
+	Mime      string
+	DesktopId string
+}
+
+func queryMime(opn *opnlib.Opn, mimeType string) {
+	var result []opnlib.MimeDesktopIds
+	if opnlib.IsMimePattern(mimeType) {
+		mimes, err := opn.GetMimesMatching(mimeType)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		result = make([]opnlib.MimeDesktopIds, 0, len(mimes))
+		for _, mime := range mimes {
+			result = append(result, opnlib.MimeDesktopIds{
+				Mime:       mime,
+				DesktopIds: opn.GetDesktopIdsForMime(mime),
+			})
+		}
+
+		if len(result) == 0 {
+			log.Printf("No MIME types match %s\n", mimeType)
+		}
+	} else {
+		result = opn.GetDesktopIdsForBroadMime(mimeType)
 	}
-	err := opn.Load()
-	switch {
-	case errors.Is(err, opnlib.FailedToSaveCache):
-		log.Printf("%v\n", err)
-	case err != nil:
-		log.Fatalf("Failed to load: %v", err)
+
+	var records []mimeDesktopId
+	for _, item := range result {
+		for _, desktopId := range item.DesktopIds {
+			records = append(records, mimeDesktopId{
+				Mime:      item.Mime,
+				DesktopId: desktopId,
+			})
+		}
 	}
-	result := opn.GetDesktopIdsForBroadMime(mimeType)
 
-	switch format {
-	case outputVerbose:
+	seen := make(map[string]bool)
+	printResult(result, func() {
 		for _, item := range result {
 			if len(item.DesktopIds) == 0 {
 				fmt.Printf("%s: No associated applications\n", item.Mime)
@@ -47,10 +85,13 @@ func queryMime(mimeType string) {
 				fmt.Printf("%s: %s\n", item.Mime, strings.Join(item.DesktopIds, ", "))
 			}
 		}
-	case outputJson:
-		err := json.NewEncoder(os.Stdout).Encode(result)
-		if err != nil {
-			log.Fatalf("Failed to encode JSON: %v", err)
+	}, records, func(record mimeDesktopId) string {
+		// A desktop ID can be associated with multiple of the MIME types, only print it once.
+		if seen[record.DesktopId] {
+			return ""
 		}
-	}
+		seen[record.DesktopId] = true
+
+		return record.DesktopId
+	})
 }
@@ -0,0 +1,64 @@
+package query
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// printResult prints the result of a query in the format chosen using --format.
+//   - jsonValue is encoded for the json format.
+//   - printVerbose is called for the verbose format.
+//   - records are used for the lines, nul, and template formats. For lines and nul, line returns
+//     the value printed for each record. Empty values are skipped. For template, the template is
+//     executed for every record.
+func printResult[T any](jsonValue any, printVerbose func(), records []T, line func(T) string) {
+	switch format.mode {
+	case outputVerbose:
+		printVerbose()
+	case outputJson:
+		err := json.NewEncoder(os.Stdout).Encode(jsonValue)
+		if err != nil {
+			log.Fatalf("Failed to encode JSON: %v", err)
+		}
+	case outputLines, outputNul:
+		delimiter := "\n"
+		if format.mode == outputNul {
+			delimiter = "\x00"
+		}
+
+		w := bufio.NewWriter(os.Stdout)
+		for _, record := range records {
+			value := line(record)
+			if value == "" {
+				continue
+			}
+
+			_, err := w.WriteString(value + delimiter)
+			if err != nil {
+				log.Fatalf("Failed to write output: %v", err)
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			log.Fatalf("Failed to write output: %v", err)
+		}
+	case outputTemplate:
+		w := bufio.NewWriter(os.Stdout)
+		for _, record := range records {
+			err := format.template.Execute(w, record)
+			if err != nil {
+				log.Fatalf("Failed to execute template: %v", err)
+			}
+
+			if _, err := w.WriteString("\n"); err != nil {
+				log.Fatalf("Failed to write output: %v", err)
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			log.Fatalf("Failed to write output: %v", err)
+		}
+	}
+}
@@ -1,24 +1,114 @@
 package query
 
 import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/opn/pkg/opnlib"
 	"github.com/spf13/cobra"
-	"github.com/thediveo/enumflag/v2"
 	"log"
+	"strings"
+	"text/template"
 )
 
-type outputMode enumflag.Flag
+type outputMode int
 
 const (
-	outputVerbose = iota
+	outputVerbose outputMode = iota
 	outputJson
+	outputLines
+	outputNul
+	outputTemplate
 )
 
+const templatePrefix = "template="
+
+var outputModeNames = map[outputMode]string{
+	outputJson:     "json",
+	outputVerbose:  "verbose",
+	outputLines:    "lines",
+	outputNul:      "nul",
+	outputTemplate: "template",
+}
+
+// outputFormat is the value of the --format flag.
+type outputFormat struct {
+	mode outputMode
+
+	// template is only set when mode is outputTemplate.
+	template *template.Template
+	text     string
+}
+
+func (f *outputFormat) String() string {
+	if f.mode == outputTemplate {
+		return templatePrefix + f.text
+	}
+
+	return outputModeNames[f.mode]
+}
+
+func (f *outputFormat) Set(value string) error {
+	if text, found := strings.CutPrefix(value, templatePrefix); found {
+		tmpl, err := template.New("format").Parse(text)
+		if err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+
+		f.mode = outputTemplate
+		f.template = tmpl
+		f.text = text
+		return nil
+	}
+
+	for mode, name := range outputModeNames {
+		if mode != outputTemplate && strings.EqualFold(name, value) {
+			f.mode = mode
+			f.template = nil
+			f.text = ""
+			return nil
+		}
+	}
+
+	return fmt.Errorf("must be one of json, verbose, lines, nul, or template=<template>")
+}
+
+func (f *outputFormat) Type() string {
+	return "format"
+}
+
 var skipCache bool
-var format outputMode
+var format outputFormat
+
+// mustLoadOpn loads the index, saving it if necessary, and exits if this fails.
+func mustLoadOpn() *opnlib.Opn {
+	opn := &opnlib.Opn{
+		SkipCache: skipCache,
+	}
+	err := opn.LoadAndSave()
+	switch {
+	case errors.Is(err, opnlib.FailedToSaveCache):
+		log.Printf("%v\n", err)
+	case err != nil:
+		log.Fatalf("Failed to load: %v", err)
+	}
 
-var outputModeMap = map[outputMode][]string{
-	outputJson:    {"json"},
-	outputVerbose: {"verbose"},
+	return opn
+}
+
+// resolveDesktopId returns the desktop ID with the .desktop suffix added if no desktop file
+// exists for the given ID and it lacks the suffix.
+func resolveDesktopId(opn *opnlib.Opn, desktopId string) string {
+	if len(opn.GetDesktopFileLocations(desktopId)) > 0 ||
+		strings.HasSuffix(desktopId, ".desktop") {
+		return desktopId
+	}
+
+	log.Printf(
+		"No desktop file found with ID %s, but it does not end in .desktop. "+
+			"Assuming it was forgotten.",
+		desktopId,
+	)
+	return desktopId + ".desktop"
 }
 
 var QueryCmd = &cobra.Command{
@@ -32,10 +122,24 @@ $ opn query mime <MIME type>
 
 Get the locations of the .desktop files for a given desktop ID:
 $ opn query desktop-locations <desktop ID>
+
+Get the installed applications and the MIME types they support:
+$ opn query apps [desktop ID]
+
+Get the MIME types a desktop ID is associated with and why:
+$ opn query associations <desktop ID>
+
+Print the desktop IDs that can open a PDF, one per line:
+$ opn query mime application/pdf --format lines
+
+Use a custom template:
+$ opn query mime application/pdf --format template='{{.Mime}} {{.DesktopId}}'
 `,
 }
 
 func init() {
+	QueryCmd.AddCommand(appsCmd)
+	QueryCmd.AddCommand(associationsCmd)
 	QueryCmd.AddCommand(mimeCmd)
 	QueryCmd.AddCommand(fileCmd)
 	QueryCmd.AddCommand(desktopLocationsCmd)
@@ -47,22 +151,33 @@ func init() {
 		"Do not use the cache. Instead, all lookups are performed on the file system.",
 	)
 
-	formatFlag := enumflag.New(&format, "format", outputModeMap, enumflag.EnumCaseInsensitive)
 	const formatFlagName = "format"
 	QueryCmd.PersistentFlags().Var(
-		formatFlag,
+		&format,
 		formatFlagName,
-		`Sets the output format. Either json or verbose. The verbose output is not stable.
-If the result is to be processed by a script, use the json format.`)
+		`Sets the output format. The verbose output is not stable.
+If the result is to be processed by a script, use any of the other formats.
+  json: the result is encoded as JSON.
+  lines: one value per line, e.g. a desktop ID.
+  nul: like lines but NUL delimited, for use with xargs -0.
+  template=<template>: a Go template executed for every result item, e.g.
+    template='{{.Mime}} {{.DesktopId}}'.
+    The fields differ from the JSON output and are listed in the help of each
+    subcommand.`)
 
-	err := formatFlag.RegisterCompletion(QueryCmd, formatFlagName, enumflag.Help[outputMode]{
-		outputJson:    "Stdout is JSON.",
-		outputVerbose: "Stdout is verbose text and not stable.",
-	})
+	err := QueryCmd.RegisterFlagCompletionFunc(
+		formatFlagName,
+		func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return []string{
+				"json\tStdout is JSON.",
+				"verbose\tStdout is verbose text and not stable.",
+				"lines\tStdout contains one value per line.",
+				"nul\tStdout contains NUL delimited values.",
+				"template=\tStdout is the result of the given Go template.",
+			}, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+		},
+	)
 	if err != nil {
 		log.Printf("failed to register shell completion of query --format flag: %v\n", err)
 	}
-
-	// @todo Add compact option with separator. 0 => nul delimited, all the rest, simple
-	//   replacement.
 }
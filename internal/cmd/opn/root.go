@@ -59,6 +59,7 @@ func init() {
 	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
 
 	rootCmd.AddCommand(cache.CacheCmd)
+	rootCmd.AddCommand(daemonCmd)
 	rootCmd.AddCommand(openFileCmd)
 	rootCmd.AddCommand(openResourceCmd)
 	rootCmd.AddCommand(openUrlCmd)
@@ -0,0 +1,127 @@
+package opn
+
+import (
+	"fmt"
+	"github.com/MatthiasKunnen/opn/pkg/opnlib"
+	"github.com/mattn/go-shellwords"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// activationTokenProvider obtains a token that allows the launched application to focus its
+// window, see the XDG activation protocol and the startup notification spec.
+type activationTokenProvider interface {
+	getToken(desktopId string, entry opnlib.DesktopEntry) (string, error)
+}
+
+// envTokenProvider forwards the token that opn itself was started with.
+type envTokenProvider struct{}
+
+func (envTokenProvider) getToken(string, opnlib.DesktopEntry) (string, error) {
+	for _, envVar := range []string{"XDG_ACTIVATION_TOKEN", "DESKTOP_STARTUP_ID"} {
+		if token := os.Getenv(envVar); token != "" {
+			return token, nil
+		}
+	}
+
+	return "", nil
+}
+
+// commandTokenProvider runs a command that prints the token, e.g. a compositor specific helper.
+// The command receives the desktop ID and the StartupWMClass using the OPN_DESKTOP_ID and
+// OPN_STARTUP_WM_CLASS environment variables.
+type commandTokenProvider struct {
+	args []string
+}
+
+func (p commandTokenProvider) getToken(desktopId string, entry opnlib.DesktopEntry) (string, error) {
+	cmd := exec.Command(p.args[0], p.args[1:]...)
+	cmd.Env = append(
+		os.Environ(),
+		"OPN_DESKTOP_ID="+desktopId,
+		"OPN_STARTUP_WM_CLASS="+entry.StartupWMClass,
+	)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error running %s: %w", strings.Join(p.args, " "), err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// getActivationTokenProviders returns the providers in order of preference.
+// OPN_ACTIVATION_TOKEN_CMD configures the command of the commandTokenProvider.
+func getActivationTokenProviders() ([]activationTokenProvider, error) {
+	providers := []activationTokenProvider{envTokenProvider{}}
+
+	envVal := os.Getenv("OPN_ACTIVATION_TOKEN_CMD")
+	if envVal == "" {
+		return providers, nil
+	}
+
+	args, err := shellwords.Parse(envVal)
+	if err != nil || len(args) == 0 {
+		return nil, fmt.Errorf("failed to parse OPN_ACTIVATION_TOKEN_CMD=%s: %v", envVal, err)
+	}
+
+	return append(providers, commandTokenProvider{args: args}), nil
+}
+
+// dryRunActivationToken is shown instead of an activation token on dry runs as obtaining a token
+// can run OPN_ACTIVATION_TOKEN_CMD.
+const dryRunActivationToken = "<activation-token>"
+
+// supportsActivation returns true if the application supports startup notification.
+func supportsActivation(entry opnlib.DesktopEntry) bool {
+	return entry.StartupNotify || entry.StartupWMClass != ""
+}
+
+// getActivationToken returns the token for the application or an empty string if the
+// application does not support startup notification or no token could be obtained.
+func getActivationToken(desktopId string, entry opnlib.DesktopEntry) (string, error) {
+	if !supportsActivation(entry) {
+		return "", nil
+	}
+
+	providers, err := getActivationTokenProviders()
+	if err != nil {
+		return "", err
+	}
+
+	for _, provider := range providers {
+		token, err := provider.getToken(desktopId, entry)
+		if err != nil {
+			return "", err
+		}
+
+		if token != "" {
+			return token, nil
+		}
+	}
+
+	return "", nil
+}
+
+// getTokenEnv returns the environment variables that pass the token to the application.
+// The token is set for both Wayland and X11 as the application can use either.
+func getTokenEnv(token string) []string {
+	if token == "" {
+		return nil
+	}
+
+	return []string{"XDG_ACTIVATION_TOKEN=" + token, "DESKTOP_STARTUP_ID=" + token}
+}
+
+// getLaunchEnv returns the environment of a detached application. The activation token of opn
+// itself is not inherited as a token can only be used once, only the given token is passed.
+func getLaunchEnv(token string) []string {
+	env := slices.DeleteFunc(os.Environ(), func(v string) bool {
+		return strings.HasPrefix(v, "XDG_ACTIVATION_TOKEN=") ||
+			strings.HasPrefix(v, "DESKTOP_STARTUP_ID=")
+	})
+
+	return append(env, getTokenEnv(token)...)
+}
@@ -0,0 +1,95 @@
+package opn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var errNoSessionBus = errors.New("no D-Bus session bus available")
+
+// isSessionBusAvailable returns true if a D-Bus session bus can be connected to.
+func isSessionBusAvailable() bool {
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
+		return true
+	}
+
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return false
+	}
+
+	_, err := os.Stat(filepath.Join(runtimeDir, "bus"))
+	return err == nil
+}
+
+// getDbusObjectPath returns the object path of the application with the given D-Bus name, see
+// https://specifications.freedesktop.org/desktop-entry-spec/1.5/dbus.html.
+func getDbusObjectPath(busName string) string {
+	return "/" + strings.NewReplacer(".", "/", "-", "_").Replace(busName)
+}
+
+// activateDbus opens the URIs with the application using org.freedesktop.Application.Open on the
+// session bus. busctl is used if it is installed, otherwise gdbus. If the activation token is not
+// empty, it is passed in the platform data.
+func activateDbus(desktopId string, uris []string, token string) error {
+	if !isSessionBusAvailable() {
+		return errNoSessionBus
+	}
+
+	busName := strings.TrimSuffix(desktopId, ".desktop")
+	objectPath := getDbusObjectPath(busName)
+
+	var args []string
+	if _, err := exec.LookPath("busctl"); err == nil {
+		args = []string{
+			"busctl", "--user", "call", busName, objectPath,
+			"org.freedesktop.Application", "Open", "asa{sv}", fmt.Sprint(len(uris)),
+		}
+		args = append(args, uris...)
+		if token == "" {
+			args = append(args, "0")
+		} else {
+			args = append(args, "2", "activation-token", "s", token, "desktop-startup-id", "s", token)
+		}
+	} else if _, err := exec.LookPath("gdbus"); err == nil {
+		quoted := make([]string, 0, len(uris))
+		for _, uri := range uris {
+			quoted = append(quoted, quoteGVariantString(uri))
+		}
+
+		platformData := "@a{sv} {}"
+		if token != "" {
+			platformData = fmt.Sprintf(
+				"{'activation-token': <%[1]s>, 'desktop-startup-id': <%[1]s>}",
+				quoteGVariantString(token),
+			)
+		}
+
+		args = []string{
+			"gdbus", "call", "--session",
+			"--dest", busName,
+			"--object-path", objectPath,
+			"--method", "org.freedesktop.Application.Open",
+			"[" + strings.Join(quoted, ", ") + "]",
+			platformData,
+		}
+	} else {
+		return errors.New("neither busctl nor gdbus is installed")
+	}
+
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// quoteGVariantString returns the string in the GVariant text format.
+func quoteGVariantString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,150 @@
+package opn
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeStubs creates scripts with the given names that record their arguments, or fail with the
+// given output if it is not empty. The directory is used as PATH and the record file is returned.
+func writeStubs(t *testing.T, failOutput string, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	record := filepath.Join(dir, "record")
+
+	script := "#!/bin/sh\nprintf '%s\\0' \"${0##*/}\" \"$@\" > '" + record + "'\n"
+	if failOutput != "" {
+		script += "echo '" + failOutput + "' >&2\nexit 1\n"
+	}
+
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("PATH", dir)
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path=/run/user/1000/bus")
+
+	return record
+}
+
+// readRecord returns the recorded arguments, including the name of the stub.
+func readRecord(t *testing.T, record string) []string {
+	t.Helper()
+	content, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatalf("expected the stub to be called: %v", err)
+	}
+
+	return strings.Split(strings.TrimSuffix(string(content), "\x00"), "\x00")
+}
+
+func TestActivateDbus(t *testing.T) {
+	uris := []string{"file:///tmp/a%20file.pdf", "https://example.com/it's"}
+	token := `token'with\quotes`
+
+	tests := []struct {
+		name     string
+		stubs    []string
+		token    string
+		expected []string
+	}{
+		{
+			name:  "busctl",
+			stubs: []string{"busctl", "gdbus"},
+			expected: []string{
+				"busctl", "--user", "call", "org.gnome.Evince", "/org/gnome/Evince",
+				"org.freedesktop.Application", "Open", "asa{sv}", "2",
+				uris[0], uris[1],
+				"0",
+			},
+		},
+		{
+			name:  "busctl with token",
+			stubs: []string{"busctl"},
+			token: token,
+			expected: []string{
+				"busctl", "--user", "call", "org.gnome.Evince", "/org/gnome/Evince",
+				"org.freedesktop.Application", "Open", "asa{sv}", "2",
+				uris[0], uris[1],
+				"2", "activation-token", "s", token, "desktop-startup-id", "s", token,
+			},
+		},
+		{
+			name:  "gdbus",
+			stubs: []string{"gdbus"},
+			expected: []string{
+				"gdbus", "call", "--session",
+				"--dest", "org.gnome.Evince",
+				"--object-path", "/org/gnome/Evince",
+				"--method", "org.freedesktop.Application.Open",
+				`['file:///tmp/a%20file.pdf', 'https://example.com/it\'s']`,
+				"@a{sv} {}",
+			},
+		},
+		{
+			name:  "gdbus with token",
+			stubs: []string{"gdbus"},
+			token: token,
+			expected: []string{
+				"gdbus", "call", "--session",
+				"--dest", "org.gnome.Evince",
+				"--object-path", "/org/gnome/Evince",
+				"--method", "org.freedesktop.Application.Open",
+				`['file:///tmp/a%20file.pdf', 'https://example.com/it\'s']`,
+				`{'activation-token': <'token\'with\\quotes'>, ` +
+					`'desktop-startup-id': <'token\'with\\quotes'>}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := writeStubs(t, "", tt.stubs...)
+			if err := activateDbus("org.gnome.Evince.desktop", uris, tt.token); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readRecord(t, record); !slices.Equal(got, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestActivateDbusErrors(t *testing.T) {
+	t.Run("no tools", func(t *testing.T) {
+		writeStubs(t, "")
+		err := activateDbus("org.gnome.Evince.desktop", nil, "")
+		if err == nil || !strings.Contains(err.Error(), "neither busctl nor gdbus") {
+			t.Errorf("expected an error about the missing tools, got %v", err)
+		}
+	})
+
+	t.Run("no session bus", func(t *testing.T) {
+		record := writeStubs(t, "", "busctl")
+		t.Setenv("DBUS_SESSION_BUS_ADDRESS", "")
+		t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+		err := activateDbus("org.gnome.Evince.desktop", nil, "")
+		if !errors.Is(err, errNoSessionBus) {
+			t.Errorf("expected errNoSessionBus, got %v", err)
+		}
+		if _, err := os.Stat(record); err == nil {
+			t.Error("expected busctl not to be called")
+		}
+	})
+
+	t.Run("call fails", func(t *testing.T) {
+		writeStubs(t, "Unknown object /org/gnome/Evince", "busctl")
+		err := activateDbus("org.gnome.Evince.desktop", nil, "")
+		if err == nil || !strings.Contains(err.Error(), "Unknown object /org/gnome/Evince") {
+			t.Errorf("expected the output of busctl in the error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package opn
+
+import (
+	"fmt"
+	"strings"
+)
+
+// printDryRun prints how the command would be started. env holds the variables that are added to
+// the environment of opn.
+func printDryRun(dir string, env []string, arguments []string) {
+	quoted := make([]string, 0, len(arguments))
+	for _, arg := range arguments {
+		quoted = append(quoted, shellQuote(arg))
+	}
+
+	fmt.Printf("Command: %s\n", strings.Join(quoted, " "))
+	if dir != "" {
+		fmt.Printf("Working directory: %s\n", dir)
+	}
+	for _, v := range env {
+		fmt.Printf("Environment: %s\n", shellQuote(v))
+	}
+}
+
+// shellQuote quotes the argument for use in a POSIX shell if required.
+func shellQuote(arg string) string {
+	if arg != "" && !strings.ContainsFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			strings.ContainsRune("@%+=:,./_-", r))
+	}) {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,186 @@
+package opn
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/MatthiasKunnen/xdg/basedir"
+	"github.com/mattn/go-shellwords"
+	"os"
+	"path"
+	"strings"
+)
+
+// launchOverride changes how an application is started. It is configured per desktop ID in the
+// launch configuration file, e.g.:
+//
+//	[firefox.desktop]
+//	Args=--new-window
+//	Env=GDK_BACKEND=x11;MOZ_ENABLE_WAYLAND=0
+//	UnsetEnv=WAYLAND_DISPLAY
+//	Wrapper=firejail --noprofile
+type launchOverride struct {
+	// args are inserted before the file or URL, or appended if the arguments do not contain it.
+	args []string
+
+	// setEnv holds the NAME=VALUE variables to set.
+	setEnv []string
+
+	// unsetEnv holds the names of the variables to unset.
+	unsetEnv []string
+
+	// wrapper is the command that starts the application, e.g. firejail or gamemoderun.
+	wrapper []string
+}
+
+// getLaunchConfigPath returns the path of the launch configuration file. OPN_LAUNCH_CONFIG
+// overrides the default, the first opn/launch.conf that exists in XDG_CONFIG_HOME and
+// XDG_CONFIG_DIRS. An empty string is returned if no file exists.
+func getLaunchConfigPath() string {
+	if envVal := os.Getenv("OPN_LAUNCH_CONFIG"); envVal != "" {
+		return envVal
+	}
+
+	dirs := append([]string{basedir.ConfigHome}, basedir.ConfigDirs...)
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+
+		configPath := path.Join(dir, "opn/launch.conf")
+		if _, err := os.Stat(configPath); err == nil {
+			return configPath
+		}
+	}
+
+	return ""
+}
+
+// loadLaunchOverride returns the override of the desktop ID. If there is no launch configuration
+// file or it does not contain the desktop ID, an empty override is returned.
+func loadLaunchOverride(desktopId string) (launchOverride, error) {
+	configPath := getLaunchConfigPath()
+	if configPath == "" {
+		return launchOverride{}, nil
+	}
+
+	file, err := os.Open(configPath)
+	if err != nil {
+		return launchOverride{}, err
+	}
+	defer file.Close()
+
+	var result launchOverride
+	inSection := false
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			inSection = line[1:len(line)-1] == desktopId
+			continue
+		}
+
+		if !inSection {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return launchOverride{}, fmt.Errorf("%s:%d: expected key=value", configPath, lineNumber)
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Args":
+			result.args, err = shellwords.Parse(value)
+		case "Wrapper":
+			result.wrapper, err = shellwords.Parse(value)
+		case "Env":
+			result.setEnv, err = parseEnvList(value)
+		case "UnsetEnv":
+			result.unsetEnv = splitList(value)
+		default:
+			err = fmt.Errorf("unknown key %s", key)
+		}
+		if err != nil {
+			return launchOverride{}, fmt.Errorf("%s:%d: %w", configPath, lineNumber, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return launchOverride{}, fmt.Errorf("error reading %s: %w", configPath, err)
+	}
+
+	return result, nil
+}
+
+// splitList splits the semicolon separated list, ignoring empty items.
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// parseEnvList parses the semicolon separated list of NAME=VALUE items.
+func parseEnvList(value string) ([]string, error) {
+	result := splitList(value)
+	for _, item := range result {
+		name, _, found := strings.Cut(item, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid environment variable %s, expected NAME=VALUE", item)
+		}
+	}
+
+	return result, nil
+}
+
+// isEmpty returns true if the override does not change the launch.
+func (l launchOverride) isEmpty() bool {
+	return len(l.args) == 0 && len(l.setEnv) == 0 && len(l.unsetEnv) == 0 && len(l.wrapper) == 0
+}
+
+// apply returns the arguments with the override applied. target is the file or URL that is opened.
+// The environment is changed using env so that it also applies when the application is started by
+// a terminal, multiplexer, or systemd, which do not pass the environment of opn.
+func (l launchOverride) apply(arguments []string, target string) []string {
+	if l.isEmpty() {
+		return arguments
+	}
+
+	var result []string
+	if len(l.setEnv) > 0 || len(l.unsetEnv) > 0 {
+		result = append(result, "env")
+		for _, name := range l.unsetEnv {
+			result = append(result, "-u", name)
+		}
+		result = append(result, l.setEnv...)
+	}
+
+	// Options are inserted before the target as many programs stop parsing options at the first
+	// operand. The program itself is never the target.
+	targetIndex := len(arguments)
+	for i := 1; i < len(arguments) && target != ""; i++ {
+		if arguments[i] == target {
+			targetIndex = i
+			break
+		}
+	}
+
+	result = append(result, l.wrapper...)
+	result = append(result, arguments[:targetIndex]...)
+	result = append(result, l.args...)
+
+	return append(result, arguments[targetIndex:]...)
+}
@@ -0,0 +1,71 @@
+package opn
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLaunchOverrideApply(t *testing.T) {
+	tests := []struct {
+		name      string
+		override  launchOverride
+		arguments []string
+		target    string
+		expected  []string
+	}{
+		{
+			name:      "empty",
+			arguments: []string{"firefox", "https://example.com"},
+			target:    "https://example.com",
+			expected:  []string{"firefox", "https://example.com"},
+		},
+		{
+			name:      "args before the target",
+			override:  launchOverride{args: []string{"--new-window"}},
+			arguments: []string{"firefox", "--private", "https://example.com", "--"},
+			target:    "https://example.com",
+			expected: []string{
+				"firefox", "--private", "--new-window", "https://example.com", "--",
+			},
+		},
+		{
+			name:      "args appended without target",
+			override:  launchOverride{args: []string{"--new-window"}},
+			arguments: []string{"firefox"},
+			target:    "https://example.com",
+			expected:  []string{"firefox", "--new-window"},
+		},
+		{
+			name:      "program named like the target",
+			override:  launchOverride{args: []string{"-n"}},
+			arguments: []string{"x", "x"},
+			target:    "x",
+			expected:  []string{"x", "-n", "x"},
+		},
+		{
+			name: "environment and wrapper",
+			override: launchOverride{
+				args:     []string{"-a"},
+				setEnv:   []string{"GDK_BACKEND=x11"},
+				unsetEnv: []string{"WAYLAND_DISPLAY"},
+				wrapper:  []string{"firejail", "--noprofile"},
+			},
+			arguments: []string{"evince", "/tmp/a.pdf"},
+			target:    "/tmp/a.pdf",
+			expected: []string{
+				"env", "-u", "WAYLAND_DISPLAY", "GDK_BACKEND=x11",
+				"firejail", "--noprofile",
+				"evince", "-a", "/tmp/a.pdf",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.override.apply(tt.arguments, tt.target)
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
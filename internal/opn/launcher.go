@@ -0,0 +1,107 @@
+package opn
+
+import (
+	"fmt"
+	"github.com/MatthiasKunnen/opn/internal/util"
+	"log"
+	"os"
+	"strings"
+)
+
+// launcher determines how detached applications are started.
+type launcher int
+
+const (
+	// launcherDirect starts the application in a new session.
+	launcherDirect launcher = iota
+
+	// launcherSystemdScope starts the application in a transient systemd user scope.
+	launcherSystemdScope
+
+	// launcherSystemdService starts the application as a transient systemd user service.
+	launcherSystemdService
+)
+
+var launcherNames = map[string]launcher{
+	"direct":          launcherDirect,
+	"systemd-scope":   launcherSystemdScope,
+	"systemd-service": launcherSystemdService,
+}
+
+// getLauncher returns the launcher configured using OPN_LAUNCHER.
+func getLauncher() launcher {
+	envVal := os.Getenv("OPN_LAUNCHER")
+	if envVal == "" {
+		return launcherDirect
+	}
+
+	l, ok := launcherNames[envVal]
+	if !ok {
+		log.Fatalf(
+			"Unknown OPN_LAUNCHER: '%s'. Expected 'direct', 'systemd-scope', or 'systemd-service'",
+			envVal,
+		)
+	}
+
+	return l
+}
+
+// wrap returns the command that starts the given command using the launcher. env holds the
+// variables that must be passed to services as they do not inherit the environment of opn.
+// The systemd units are named according to the XDG application cgroup naming convention, see
+// https://systemd.io/DESKTOP_ENVIRONMENTS/#xdg-standardization-for-applications.
+func (l launcher) wrap(desktopId string, env []string, arguments []string) []string {
+	appId := escapeUnitName(strings.TrimSuffix(desktopId, ".desktop"))
+	random := util.RandString(10)
+
+	var systemdArgs []string
+	switch l {
+	case launcherSystemdScope:
+		systemdArgs = []string{
+			"systemd-run",
+			"--user",
+			"--scope",
+			"--quiet",
+			"--slice=app.slice",
+			fmt.Sprintf("--unit=app-opn-%s-%s.scope", appId, random),
+		}
+	case launcherSystemdService:
+		// Services inherit the environment of the service manager, not the one of opn
+		systemdArgs = []string{
+			"systemd-run",
+			"--user",
+			"--quiet",
+			"--collect",
+			"--same-dir",
+			"--service-type=exec",
+			"--slice=app.slice",
+			fmt.Sprintf("--unit=app-opn-%s@%s.service", appId, random),
+		}
+		for _, v := range env {
+			systemdArgs = append(systemdArgs, "--setenv="+v)
+		}
+	default:
+		return arguments
+	}
+
+	return append(append(systemdArgs, "--"), arguments...)
+}
+
+// escapeUnitName escapes the string for use in a systemd unit name, like systemd-escape. Dashes
+// are escaped as well since they separate the components of the unit name.
+func escapeUnitName(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == ':':
+			b.WriteByte(c)
+		case c == '.' && i > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package opn
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLauncherWrap(t *testing.T) {
+	arguments := []string{"evince", "/tmp/a file.pdf"}
+	// The token is passed as a single argument without a shell, it must not be altered
+	token := `gnome-shell/evince/1234-0-host_TIME42 'quoted' "double" $HOME;x=y`
+	env := getTokenEnv(token)
+
+	tests := []struct {
+		name     string
+		launcher launcher
+		expected []string
+		unit     string
+	}{
+		{
+			name:     "direct",
+			launcher: launcherDirect,
+			expected: arguments,
+		},
+		{
+			name:     "systemd-scope",
+			launcher: launcherSystemdScope,
+			expected: []string{
+				"systemd-run",
+				"--user",
+				"--scope",
+				"--quiet",
+				"--slice=app.slice",
+				"UNIT",
+				"--",
+				"evince",
+				"/tmp/a file.pdf",
+			},
+			unit: `^--unit=app-opn-org\.gnome\.Evince-[a-zA-Z0-9]{10}\.scope$`,
+		},
+		{
+			name:     "systemd-service",
+			launcher: launcherSystemdService,
+			expected: []string{
+				"systemd-run",
+				"--user",
+				"--quiet",
+				"--collect",
+				"--same-dir",
+				"--service-type=exec",
+				"--slice=app.slice",
+				"UNIT",
+				"--setenv=XDG_ACTIVATION_TOKEN=" + token,
+				"--setenv=DESKTOP_STARTUP_ID=" + token,
+				"--",
+				"evince",
+				"/tmp/a file.pdf",
+			},
+			unit: `^--unit=app-opn-org\.gnome\.Evince@[a-zA-Z0-9]{10}\.service$`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := test.launcher.wrap("org.gnome.Evince.desktop", env, arguments)
+
+			// The unit name contains a random part, it is checked separately
+			unitIndex := slices.Index(test.expected, "UNIT")
+			if unitIndex != -1 && unitIndex < len(result) {
+				if !regexp.MustCompile(test.unit).MatchString(result[unitIndex]) {
+					t.Errorf("unit %s does not match %s", result[unitIndex], test.unit)
+				}
+				result = slices.Clone(result)
+				result[unitIndex] = "UNIT"
+			}
+
+			if !slices.Equal(result, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestLauncherWrapWithoutToken(t *testing.T) {
+	result := launcherSystemdService.wrap("foot.desktop", getTokenEnv(""), []string{"foot"})
+	for _, arg := range result {
+		if strings.HasPrefix(arg, "--setenv=") {
+			t.Errorf("expected no --setenv without token, got %q", result)
+		}
+	}
+}
+
+func TestEscapeUnitName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"org.gnome.Evince", "org.gnome.Evince"},
+		{"my_app:1", "my_app:1"},
+		{"my-app", `my\x2dapp`},
+		{"my app", `my\x20app`},
+		{".hidden", `\x2ehidden`},
+		{"a/b", `a\x2fb`},
+		{"é", `\xc3\xa9`},
+		{`back\slash`, `back\x5cslash`},
+	}
+
+	for _, test := range tests {
+		if result := escapeUnitName(test.input); result != test.expected {
+			t.Errorf("escapeUnitName(%q): expected %q, got %q", test.input, test.expected, result)
+		}
+	}
+}
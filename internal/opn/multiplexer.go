@@ -0,0 +1,157 @@
+package opn
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// multiplexer is a terminal multiplexer, or a terminal emulator, that can open a program in a new
+// window or pane of the terminal that opn is running in.
+type multiplexer int
+
+const (
+	multiplexerNone multiplexer = iota
+	multiplexerTmux
+	multiplexerZellij
+	multiplexerKitty
+	multiplexerWezterm
+)
+
+// detectMultiplexer returns the multiplexer opn is running in. Multiplexers are checked before
+// terminal emulators as a multiplexer running in e.g. kitty inherits the variables of kitty.
+func detectMultiplexer() multiplexer {
+	switch {
+	case os.Getenv("TMUX") != "":
+		return multiplexerTmux
+	case os.Getenv("ZELLIJ") != "":
+		return multiplexerZellij
+	case os.Getenv("KITTY_WINDOW_ID") != "":
+		return multiplexerKitty
+	case os.Getenv("WEZTERM_PANE") != "":
+		return multiplexerWezterm
+	default:
+		return multiplexerNone
+	}
+}
+
+func (m multiplexer) String() string {
+	switch m {
+	case multiplexerTmux:
+		return "tmux"
+	case multiplexerZellij:
+		return "zellij"
+	case multiplexerKitty:
+		return "kitty"
+	case multiplexerWezterm:
+		return "wezterm"
+	default:
+		return "none"
+	}
+}
+
+// supports returns true if the multiplexer can start programs using the start mode.
+func (m multiplexer) supports(mode StartMode) bool {
+	switch mode {
+	case Window:
+		return m == multiplexerTmux || m == multiplexerKitty || m == multiplexerWezterm
+	case Pane:
+		return m != multiplexerNone
+	default:
+		return false
+	}
+}
+
+// args returns the command that opens the given command in a new window or pane.
+// opts.dir must be set as the multiplexer does not share the working directory of opn.
+func (m multiplexer) args(mode StartMode, opts terminalOpts, arguments []string) []string {
+	var result []string
+	switch {
+	case m == multiplexerTmux && mode == Window:
+		result = []string{"tmux", "new-window", "-c", opts.dir}
+		if opts.title != "" {
+			result = append(result, "-n", opts.title)
+		}
+		result = append(result, "--")
+	case m == multiplexerTmux && mode == Pane:
+		result = []string{"tmux", "split-window", "-c", opts.dir, "--"}
+	case m == multiplexerZellij && mode == Pane:
+		result = []string{"zellij", "run", "--close-on-exit", "--cwd", opts.dir}
+		if opts.title != "" {
+			result = append(result, "--name", opts.title)
+		}
+		result = append(result, "--")
+	case m == multiplexerKitty:
+		launchType := "window"
+		if mode == Window {
+			launchType = "tab"
+		}
+		result = []string{"kitty", "@", "launch", "--type=" + launchType, "--cwd=" + opts.dir}
+		if opts.title != "" {
+			result = append(result, "--title="+opts.title)
+		}
+	case m == multiplexerWezterm && mode == Window:
+		result = []string{"wezterm", "cli", "spawn", "--cwd", opts.dir, "--"}
+	case m == multiplexerWezterm && mode == Pane:
+		result = []string{"wezterm", "cli", "split-pane", "--cwd", opts.dir, "--"}
+	default:
+		return nil
+	}
+
+	return append(result, arguments...)
+}
+
+// getPaneDir returns the directory to open a new window or pane in. This is dir, or the working
+// directory of opn if dir is empty, as the multiplexer does not share the working directory of opn.
+func getPaneDir(dir string) (string, error) {
+	if dir != "" {
+		return dir, nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine the working directory: %w", err)
+	}
+
+	return wd, nil
+}
+
+// paneCommand returns the command to run in a new window or pane. The program is started using
+// the launcher. As the environment of the pane is the one of the multiplexer, the activation token
+// is passed using env.
+func paneCommand(desktopId string, token string, arguments []string) []string {
+	l := getLauncher()
+	if l == launcherSystemdService {
+		// A service is not attached to the terminal of the pane
+		l = launcherSystemdScope
+	}
+
+	arguments = l.wrap(desktopId, nil, arguments)
+	if env := getTokenEnv(token); env != nil {
+		arguments = append(append([]string{"env"}, env...), arguments...)
+	}
+
+	return arguments
+}
+
+// start opens the command in a new window or pane and returns once the multiplexer has done so.
+// opts.dir must be set, see getPaneDir.
+func (m multiplexer) start(mode StartMode, opts terminalOpts, arguments []string) error {
+	if !m.supports(mode) {
+		return fmt.Errorf("%s does not support opening the program in a new %s", m, mode)
+	}
+
+	args := m.args(mode, opts, arguments)
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"%s: %w: %s",
+			strings.Join(args[:2], " "),
+			err,
+			strings.TrimSpace(string(output)),
+		)
+	}
+
+	return nil
+}
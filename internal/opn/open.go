@@ -7,14 +7,13 @@ import (
 	"github.com/MatthiasKunnen/opn/internal/util"
 	"github.com/MatthiasKunnen/opn/pkg/opnlib"
 	"github.com/MatthiasKunnen/xdg/desktop"
-	"github.com/mattn/go-shellwords"
-	"github.com/pkg/xattr"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"slices"
@@ -23,7 +22,7 @@ import (
 	"syscall"
 )
 
-var appSelectRe = regexp.MustCompile(`^(\d+)(?:\.(\d+))?([ad])?$`)
+var appSelectRe = regexp.MustCompile(`^(\d+)(?:\.(\d+))?([adwp])?$`)
 
 type StartMode int
 
@@ -31,8 +30,45 @@ const (
 	Unset StartMode = iota
 	Attached
 	Detached
+
+	// Window opens the program in a new window of the multiplexer opn is running in.
+	Window
+
+	// Pane opens the program in a new pane of the multiplexer opn is running in.
+	Pane
 )
 
+func (m StartMode) String() string {
+	switch m {
+	case Attached:
+		return "attached"
+	case Detached:
+		return "detached"
+	case Window:
+		return "window"
+	case Pane:
+		return "pane"
+	default:
+		return "unset"
+	}
+}
+
+// parseStartMode returns the start mode of the letter, or Unset if the letter is unknown.
+func parseStartMode(letter string) StartMode {
+	switch letter {
+	case "a":
+		return Attached
+	case "d":
+		return Detached
+	case "w":
+		return Window
+	case "p":
+		return Pane
+	default:
+		return Unset
+	}
+}
+
 type valueType int
 
 const (
@@ -42,16 +78,33 @@ const (
 )
 
 type desktopInfo struct {
-	Entry    *desktop.Entry
+	Entry    opnlib.DesktopEntry
 	FilePath string
 	Id       string
-	Actions  []desktop.Action
+	Actions  []opnlib.DesktopAction
+
+	// HiddenReason is set if the entry is only shown because of ShowAll.
+	HiddenReason string
 }
 
 type OpenerOpts struct {
 	MimeOverride string
 	SkipCache    bool
 
+	// ShowAll includes the applications that should be hidden according to the visibility rules
+	// of the desktop entry spec.
+	ShowAll bool
+
+	// ShowDetails shows the generic name and comment of the applications.
+	ShowDetails bool
+
+	// WorkingDir is the directory to start the application in. It takes precedence over the Path
+	// of the desktop entry. If both are empty, the working directory of opn is used.
+	WorkingDir string
+
+	// DryRun prints how the application would be started instead of starting it.
+	DryRun bool
+
 	fileOrUrl string
 	valueType valueType
 }
@@ -83,9 +136,15 @@ type opener struct {
 	localFile             string
 	localFileMime         string
 	localFileIsDownloaded bool
+	showAll               bool
+	showDetails           bool
+	workingDir            string
+	dryRun                bool
+	locale                string
 	url                   string
 	urlIsDownloadable     bool
 	urlScheme             string
+	multiplexer           multiplexer
 	opn                   *opnlib.Opn
 }
 
@@ -103,6 +162,12 @@ func newOpener(opts OpenerOpts) *opener {
 
 	o := &opener{
 		mimeOverride: opts.MimeOverride,
+		showAll:      opts.ShowAll,
+		showDetails:  opts.ShowDetails,
+		workingDir:   opts.WorkingDir,
+		dryRun:       opts.DryRun,
+		locale:       opnlib.GetMessagesLocale(),
+		multiplexer:  detectMultiplexer(),
 		opn:          opn,
 	}
 
@@ -133,6 +198,15 @@ func newOpener(opts OpenerOpts) *opener {
 		o.urlIsDownloadable = isDownloadSupported(parsedUrl.Scheme)
 	}
 
+	// The application can run in a different working directory
+	if o.localFile != "" {
+		absPath, err := filepath.Abs(o.localFile)
+		if err != nil {
+			log.Fatalf("Failed to determine the absolute path of %s: %v", o.localFile, err)
+		}
+		o.localFile = absPath
+	}
+
 	return o
 }
 
@@ -141,6 +215,11 @@ func (o *opener) run() {
 		panic("Either localFile or both o.url && o.urlScheme must be set")
 	}
 
+	if o.dryRun {
+		// The application is not started, nothing else removes a downloaded file
+		defer o.removeDownload()
+	}
+
 	o.updateLocalFileMime()
 	desktopFiles := o.mustGetOptions()
 
@@ -151,7 +230,7 @@ func (o *opener) run() {
 	scanner := bufio.NewScanner(os.Stdin)
 inputLoop:
 	for {
-		printOptions(desktopFiles)
+		o.printOptions(desktopFiles)
 		fmt.Printf(
 			"Open %s with (?=help)[0]: ",
 			o.getPrintHint(),
@@ -163,13 +242,13 @@ inputLoop:
 		case text == "":
 			mainIndex = 0
 			break inputLoop
-		case text == "a":
-			mainIndex = 0
-			startMode = Attached
-			break inputLoop
-		case text == "d":
+		case text == "a", text == "d", text == "w", text == "p":
+			if !o.isStartModeAvailable(parseStartMode(text), desktopFiles[0].Entry) {
+				continue
+			}
+
 			mainIndex = 0
-			startMode = Detached
+			startMode = parseStartMode(text)
 			break inputLoop
 		case text == "q":
 			return
@@ -201,21 +280,26 @@ a(ttached): execute program in this terminal.
   When opening with vim, this would launch vim in the current terminal.
 d(etached): launch the program detached from the terminal.
   When opening with vim, this would launch vim in a new terminal.
-
-Current defaults:
 `)
-			if startModeTerm == Attached {
-				sb.WriteString("Terminal: attached\n")
-			} else {
-				sb.WriteString("Terminal: detached\n")
+			if o.multiplexer.supports(Window) {
+				fmt.Fprintf(
+					&sb,
+					"w(indow): open the terminal program in a new %s window.\n",
+					o.multiplexer,
+				)
 			}
-
-			if startModeGui == Attached {
-				sb.WriteString("GUI: attached\n")
-			} else {
-				sb.WriteString("GUI: detached\n")
+			if o.multiplexer.supports(Pane) {
+				fmt.Fprintf(
+					&sb,
+					"p(ane): open the terminal program in a new %s pane.\n",
+					o.multiplexer,
+				)
 			}
 
+			sb.WriteString("\nCurrent defaults:\n")
+			fmt.Fprintf(&sb, "Terminal: %s\n", startModeTerm)
+			fmt.Fprintf(&sb, "GUI: %s\n", startModeGui)
+
 			if o.localFile == "" && o.url != "" {
 				sb.WriteString("\nD to download the file and update options.")
 			}
@@ -264,16 +348,13 @@ Current defaults:
 				}
 			}
 
-			if len(matches) > 2 {
-				switch matches[3] {
-				case "":
-				case "a":
-					startMode = Attached
-				case "d":
-					startMode = Detached
-				default:
-					log.Fatalf("Unknown start mode: '%s'. Exepected 'a' or 'd'.", matches[3])
+			if len(matches) > 2 && matches[3] != "" {
+				mode := parseStartMode(matches[3])
+				if !o.isStartModeAvailable(mode, desktopFiles[mainIndex].Entry) {
+					continue
 				}
+
+				startMode = mode
 			}
 
 			break inputLoop
@@ -285,6 +366,55 @@ Current defaults:
 	}
 
 	chosen := desktopFiles[mainIndex]
+	if startMode == Unset {
+		if chosen.Entry.Terminal {
+			startMode = startModeTerm
+		} else {
+			startMode = startModeGui
+		}
+
+		// The defaults can name a multiplexer start mode that is unavailable outside one
+		if (startMode == Window || startMode == Pane) && !o.multiplexer.supports(startMode) {
+			startMode = Detached
+		}
+	}
+
+	var token string
+	switch {
+	case startMode == Attached:
+	case o.dryRun:
+		if supportsActivation(chosen.Entry) {
+			token = dryRunActivationToken
+		}
+	default:
+		token, err = getActivationToken(chosen.Id, chosen.Entry)
+		if err != nil {
+			log.Printf("Failed to obtain activation token: %v\n", err)
+		}
+	}
+
+	override, err := loadLaunchOverride(chosen.Id)
+	if err != nil {
+		log.Fatalf("Error loading launch configuration: %v\n", err)
+	}
+
+	// Actions are started using Exec as ActivateAction cannot pass the file or URL. Overrides
+	// require Exec as well.
+	if startMode == Detached && actionIndex == -1 && chosen.Entry.DBusActivatable &&
+		!chosen.Entry.Terminal && override.isEmpty() {
+		if o.dryRun {
+			fmt.Printf("D-Bus activation: %s %s\n", chosen.Id, o.getUri())
+			return
+		}
+
+		err := activateDbus(chosen.Id, []string{o.getUri()}, token)
+		if err == nil {
+			return
+		}
+
+		log.Printf("D-Bus activation of %s failed, falling back to Exec: %v\n", chosen.Id, err)
+	}
+
 	var execVal desktop.ExecValue
 	if actionIndex > -1 {
 		execVal = chosen.Actions[actionIndex].Exec
@@ -292,24 +422,36 @@ Current defaults:
 		execVal = chosen.Entry.Exec
 	}
 
+	if execVal == "" {
+		log.Fatalf("%s cannot be started, it has no Exec value", chosen.Id)
+	}
+
+	// The file or URL as passed to the application, the launch configuration inserts its
+	// arguments before it
+	var target string
+	getTarget := func(mustBeLocal bool) string {
+		target = o.getExecArg(mustBeLocal)
+		return target
+	}
+
 	arguments := execVal.ToArguments(desktop.FieldCodeProvider{
 		GetDesktopFileLocation: func() string {
 			return chosen.FilePath
 		},
 		GetFile: func() string {
-			return o.getExecArg(true)
+			return getTarget(true)
 		},
 		GetFiles: func() []string {
-			return []string{o.getExecArg(true)}
+			return []string{getTarget(true)}
 		},
 		GetName: func() string {
-			return chosen.Entry.Name.Default
+			return chosen.Entry.Name.Get(o.locale)
 		},
 		GetUrl: func() string {
-			return o.getExecArg(false)
+			return getTarget(false)
 		},
 		GetUrls: func() []string {
-			return []string{o.getExecArg(false)}
+			return []string{getTarget(false)}
 		},
 	})
 
@@ -320,57 +462,144 @@ Current defaults:
 			"Warning: %s does not explicitly declare support for opening a file. "+
 				"It is missing a field code in the Exec value. "+
 				"The path will be added as last argument.\n", chosen.Id)
-		arguments = append(arguments, o.getExecArg(false))
-	}
-
-	if startMode == Unset {
-		if chosen.Entry.Terminal {
-			startMode = startModeTerm
-		} else {
-			startMode = startModeGui
-		}
+		arguments = append(arguments, getTarget(false))
 	}
 
+	arguments = override.apply(arguments, target)
+	dir := o.getWorkingDir(chosen.Entry)
 	switch startMode {
 	case Attached:
-		eCmd := exec.Command(arguments[0], arguments[1:]...)
-		// @todo Think about using syscall.Exec as this would replace the opn process and
-		//       release the resources. Gotchas are unknown.
-		eCmd.Stdin = os.Stdin
-		eCmd.Stdout = os.Stdout
-		eCmd.Stderr = os.Stderr
-		err = eCmd.Run()
+		o.startAttached(dir, arguments)
+	case Detached:
+		o.startDetached(chosen, token, dir, arguments)
+	case Window, Pane:
+		paneDir, err := getPaneDir(dir)
 		if err != nil {
-			log.Fatalf("Error running command '%s': %v\n", arguments, err)
+			log.Fatalf("Error starting command '%s': %v\n", arguments, err)
+		}
+
+		opts := terminalOpts{title: chosen.Entry.Name.Get(o.locale), dir: paneDir}
+		arguments = paneCommand(chosen.Id, token, arguments)
+		if o.dryRun {
+			printDryRun("", nil, o.multiplexer.args(startMode, opts, arguments))
+			return
+		}
+
+		err = o.multiplexer.start(startMode, opts, arguments)
+		if err != nil {
+			log.Fatalf("Error starting command '%s': %v\n", arguments, err)
 		}
-	case Detached:
-		startDetached(chosen.Entry.Terminal, arguments)
 	default:
 		log.Fatalln("Startmode not configured")
 	}
 
 }
 
+// startAttached runs the application in the current terminal.
+// By default, opn is replaced by the application using exec. This way, signals, job control, and
+// the exit status behave as if the application was started directly. A child process is used
+// instead when opn needs to clean up after the application exits, i.e. to delete a downloaded file,
+// or when OPN_ATTACH_MODE is "child".
+func (o *opener) startAttached(dir string, arguments []string) {
+	attachMode := os.Getenv("OPN_ATTACH_MODE")
+	switch attachMode {
+	case "", "exec":
+	case "child":
+	default:
+		log.Fatalf("Unknown OPN_ATTACH_MODE: '%s'. Either 'exec' or 'child' expected", attachMode)
+	}
+
+	if o.dryRun {
+		printDryRun(dir, nil, arguments)
+		return
+	}
+
+	if attachMode == "child" || o.localFileIsDownloaded {
+		o.runAttachedChild(dir, arguments)
+		return
+	}
+
+	program, err := exec.LookPath(arguments[0])
+	if err != nil {
+		log.Fatalf("Error running command '%s': %v\n", arguments, err)
+	}
+
+	// The program is looked up first as a relative PATH entry would resolve differently
+	if dir != "" {
+		err = os.Chdir(dir)
+		if err != nil {
+			log.Fatalf("Failed to change the working directory: %v\n", err)
+		}
+	}
+
+	o.opn.Close()
+	err = syscall.Exec(program, arguments, os.Environ())
+	log.Fatalf("Error running command '%s': %v\n", arguments, err)
+}
+
+// runAttachedChild runs the application as a child process, waits for it to exit, and exits with
+// the same exit status.
+func (o *opener) runAttachedChild(dir string, arguments []string) {
+	eCmd := exec.Command(arguments[0], arguments[1:]...)
+	eCmd.Dir = dir
+	eCmd.Stdin = os.Stdin
+	eCmd.Stdout = os.Stdout
+	eCmd.Stderr = os.Stderr
+
+	// Like a shell, leave interrupts to the application. The signals are caught rather than
+	// ignored as ignored signals are inherited by the application.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGQUIT)
+	err := eCmd.Run()
+	signal.Stop(signals)
+
+	o.removeDownload()
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		if ok && status.Signaled() {
+			os.Exit(128 + int(status.Signal()))
+		}
+
+		os.Exit(exitErr.ExitCode())
+	case err != nil:
+		log.Fatalf("Error running command '%s': %v\n", arguments, err)
+	}
+}
+
+// removeDownload removes the local file if it was downloaded.
+func (o *opener) removeDownload() {
+	if !o.localFileIsDownloaded {
+		return
+	}
+
+	if err := os.Remove(o.localFile); err != nil {
+		log.Printf("Failed to remove downloaded file: %v\n", err)
+	}
+}
+
 func (o *opener) updateLocalFileMime() {
 	if o.localFile == "" {
 		o.localFileMime = ""
 		return
 	}
 
-	if o.localFileMime == "" && !o.localFileIsDownloaded {
-		// If not overriden by --mime-type, try to get extended file attribute
-		if attrMime, err := xattr.Get(o.localFile, "user.mime"); err == nil {
-			o.localFileMime = string(attrMime)
-		}
+	if o.localFileMime != "" {
+		return
 	}
 
-	if o.localFileMime == "" {
-		mime, err := opnlib.GetFileMime(o.localFile)
-		if err != nil {
-			log.Fatalf("Failed to get MIME type of file %s: %v\n", o.localFile, err)
-		}
-		o.localFileMime = mime
+	resolution, err := opnlib.MimeResolver{
+		Override:  o.mimeOverride,
+		SkipXattr: o.localFileIsDownloaded,
+		MatchGlob: o.opn.MatchMimeGlob,
+	}.Resolve(o.localFile)
+	if err != nil {
+		log.Fatalf("Failed to get MIME type of file %s: %v\n", o.localFile, err)
 	}
+	// Extended file attributes and Content-Type headers often use aliases
+	o.localFileMime = o.opn.CanonicalMime(resolution.Mime)
 }
 
 func (o *opener) mustGetOptions() []*desktopInfo {
@@ -400,23 +629,18 @@ func (o *opener) mustGetOptions() []*desktopInfo {
 			}
 
 			desktopIdsSet[desktopId] = true
-			var desktopParseError error
-			var entry *desktop.Entry
-			var desktopFilePath string
-			for _, desktopFilePath = range o.opn.GetDesktopFileLocations(desktopId) {
-				entry, desktopParseError = desktop.ParseFile(desktopFilePath)
-				if desktopParseError == nil {
-					break
-				}
-
-				log.Printf("Error parsing desktop file %s: %v\n", desktopFilePath, desktopParseError)
-			}
-
-			if desktopParseError != nil || entry == nil {
+			entry, err := o.opn.GetDesktopEntry(desktopId)
+			switch {
+			case errors.Is(err, opnlib.ErrBrokenDesktopEntry):
+				// Already reported when the cache was generated
+				continue
+			case err != nil:
+				log.Printf("%v\n", err)
 				continue
 			}
 
-			if entry.NoDisplay {
+			hiddenReason := entry.HiddenReason(os.Getenv("XDG_CURRENT_DESKTOP"))
+			if hiddenReason != "" && !o.showAll {
 				continue
 			}
 
@@ -430,9 +654,11 @@ func (o *opener) mustGetOptions() []*desktopInfo {
 
 			desktopInfo := &desktopInfo{
 				Id:       desktopId,
-				FilePath: desktopFilePath,
+				FilePath: entry.FilePath,
 				Entry:    entry,
-				Actions:  make([]desktop.Action, 0),
+				Actions:  make([]opnlib.DesktopAction, 0),
+
+				HiddenReason: hiddenReason,
 			}
 			desktopFiles = append(desktopFiles, desktopInfo)
 
@@ -472,6 +698,11 @@ func (o *opener) getExecArg(mustBeLocal bool) string {
 		return o.url
 	}
 
+	if o.dryRun {
+		// The name of the file that would be downloaded to
+		return filepath.Join(os.TempDir(), downloadPattern)
+	}
+
 	o.download()
 
 	return o.localFile
@@ -486,6 +717,9 @@ func isDownloadSupported(urlScheme string) bool {
 	}
 }
 
+// downloadPattern is the pattern of the name of downloaded files, see os.CreateTemp.
+const downloadPattern = "opn_download*"
+
 func (o *opener) download() {
 	if o.url == "" {
 		log.Fatalln("Could not download, URL is not set.")
@@ -497,7 +731,7 @@ func (o *opener) download() {
 
 	log.Println("Downloading...")
 
-	temp, err := os.CreateTemp("", "opn_download")
+	temp, err := os.CreateTemp("", downloadPattern)
 	if err != nil {
 		log.Fatalf("Error creating temporary file: %v\n", err)
 	}
@@ -520,7 +754,7 @@ func (o *opener) download() {
 
 	contentType := resp.Header.Get("Content-Type")
 	if contentType != "" {
-		o.localFileMime = contentType
+		o.localFileMime = o.opn.CanonicalMime(opnlib.StripMimeParameters(contentType))
 	}
 
 	o.localFile = temp.Name()
@@ -528,6 +762,35 @@ func (o *opener) download() {
 	o.updateLocalFileMime()
 }
 
+// getUri returns the URI of the file or URL to open.
+func (o *opener) getUri() string {
+	if o.localFile == "" {
+		return o.url
+	}
+
+	return (&url.URL{Scheme: "file", Path: o.localFile}).String()
+}
+
+// getWorkingDir returns the absolute path of the directory to start the application in, or an
+// empty string if the working directory of opn is to be used.
+func (o *opener) getWorkingDir(entry opnlib.DesktopEntry) string {
+	dir := o.workingDir
+	if dir == "" {
+		dir = entry.Path
+	}
+
+	if dir == "" {
+		return ""
+	}
+
+	absPath, err := filepath.Abs(dir)
+	if err != nil {
+		log.Fatalf("Failed to determine the absolute path of %s: %v", dir, err)
+	}
+
+	return absPath
+}
+
 func (o *opener) getPrintHint() string {
 	if o.localFile != "" {
 		return filepath.Base(o.localFile)
@@ -558,28 +821,29 @@ func getDefaultStartMode() (StartMode, StartMode, StartMode) {
 
 		switch tcParts[0] {
 		case "gui":
-			switch tcParts[1] {
-			case "a":
-				startModeGui = Attached
-			case "d":
-				startModeGui = Detached
-			default:
+			startModeGui = parseStartMode(tcParts[1])
+			switch startModeGui {
+			case Unset:
 				log.Fatalf(
 					"Unknown start mode in OPN_START_MODE for gui: '%s'. "+
 						"Either 'a' or 'd' expected",
 					tcParts[1],
 				)
+			case Window, Pane:
+				// GUI applications do not need a terminal and are killed when the pane closes
+				log.Fatalf(
+					"Invalid start mode in OPN_START_MODE for gui: '%s'. "+
+						"GUI applications cannot be opened in a %s, either 'a' or 'd' expected",
+					tcParts[1],
+					startModeGui,
+				)
 			}
 		case "term":
-			switch tcParts[1] {
-			case "a":
-				startModeTerm = Attached
-			case "d":
-				startModeTerm = Detached
-			default:
+			startModeTerm = parseStartMode(tcParts[1])
+			if startModeTerm == Unset {
 				log.Fatalf(
 					"Unknown start mode in OPN_START_MODE for terminal: '%s'. "+
-						"Either 'a' or 'd' expected",
+						"Either 'a', 'd', 'w', or 'p' expected",
 					tcParts[1],
 				)
 			}
@@ -594,142 +858,227 @@ func getDefaultStartMode() (StartMode, StartMode, StartMode) {
 	return startMode, startModeGui, startModeTerm
 }
 
-func printOptions(desktopFiles []*desktopInfo) {
+// isStartModeAvailable returns true if the start mode can be used to start the application. If
+// not, the reason is printed.
+func (o *opener) isStartModeAvailable(mode StartMode, entry opnlib.DesktopEntry) bool {
+	if mode != Window && mode != Pane {
+		return true
+	}
+
+	if !entry.Terminal {
+		// The application would be killed when the window or pane closes
+		fmt.Printf("GUI applications cannot be opened in a %s\n", mode)
+		return false
+	}
+
+	if !o.multiplexer.supports(mode) {
+		if o.multiplexer == multiplexerNone {
+			fmt.Printf("Not running in tmux, zellij, kitty, or wezterm, cannot open a %s\n", mode)
+		} else {
+			fmt.Printf("Opening a %s is not supported in %s\n", mode, o.multiplexer)
+		}
+		return false
+	}
+
+	return true
+}
+
+func (o *opener) printOptions(desktopFiles []*desktopInfo) {
 	for index, desktopFile := range slices.Backward(desktopFiles) {
-		fmt.Printf("%d) %s\n", index, desktopFile.Entry.Name.Default)
+		entry := desktopFile.Entry
+		name := entry.Name.Get(o.locale)
+		genericName := entry.GenericName.Get(o.locale)
+		if o.showDetails && genericName != "" && genericName != name {
+			name += " (" + genericName + ")"
+		}
+
+		if desktopFile.HiddenReason != "" {
+			fmt.Printf("%d) %s (hidden: %s)\n", index, name, desktopFile.HiddenReason)
+		} else {
+			fmt.Printf("%d) %s\n", index, name)
+		}
+
+		if comment := entry.Comment.Get(o.locale); o.showDetails && comment != "" {
+			fmt.Printf("     %s\n", comment)
+		}
 
 		for actionIndex, action := range desktopFile.Actions {
 			fmt.Printf(
 				"  %d.%d) %s\n",
 				index,
 				actionIndex+1,
-				action.Name.Default,
+				action.Name.Get(o.locale),
 			)
 		}
 	}
 }
 
-func startDetached(isTerminal bool, arguments []string) {
-	if isTerminal {
-		terminalEnvVars := []string{"OPN_TERM_CMD", "TERMINAL_COMMAND"}
-		var terminalArgs []string
-
-		for _, envVar := range terminalEnvVars {
-			envVal := os.Getenv(envVar)
-			if envVal == "" {
-				continue
-			}
-
-			parsedArgs, err := shellwords.Parse(envVal)
-			if err != nil {
-				log.Fatalf("Failed to parse %s=%s: %v", envVar, envVal, err)
-			}
-			terminalArgs = parsedArgs
-			break
-		}
-
-		if terminalArgs == nil {
+// startDetached starts the program in a new session. The activation token, if not empty, is passed
+// to the program. The program is started in dir unless it is empty.
+func (o *opener) startDetached(chosen *desktopInfo, token string, dir string, arguments []string) {
+	desktopId := chosen.Id
+	if chosen.Entry.Terminal {
+		term, ok := getTerminal(o.opn)
+		if !ok {
 			log.Fatalf(
-				"Program needs to be opened in a new terminal but none of these environment variables are set: %s. See --help. \n",
+				"Program needs to be opened in a new terminal but none was found. "+
+					"Set one of %s or list a terminal in xdg-terminals.list. See --help.\n",
 				strings.Join(terminalEnvVars, ", "),
 			)
 		}
 
-		if util.ParentIsShell() {
-			arguments = append(terminalArgs, arguments...)
-		} else {
-			// If the parent process is not a shell, assume it is a terminal that will close
-			// immediately after opn exits. This risks taking out the newly launched detached
-			// program.
+		appId := chosen.Entry.StartupWMClass
+		if appId == "" {
+			appId = strings.TrimSuffix(desktopId, ".desktop")
+		}
+		termOpts := terminalOpts{
+			appId: appId,
+			title: chosen.Entry.Name.Get(o.locale),
+			dir:   dir,
+		}
+
+		if terminalClosesOnExit() {
+			// The terminal will close immediately after opn exits. This risks taking out the
+			// newly launched detached program.
 			// To prevent this, we need to make sure the program is launched before exiting.
-			startDetachedWithStartSignaling(terminalArgs, arguments)
+			if o.dryRun {
+				// The FIFO is only created when starting, its directory has a random name
+				fifoPath := filepath.Join(getFifoParent(), "opn-*", "start")
+				args, err := getStartSignalingArgs(
+					desktopId,
+					token,
+					term,
+					termOpts,
+					fifoPath,
+					arguments,
+				)
+				if err != nil {
+					log.Fatalf("Error starting command '%s': %v\n", arguments, err)
+				}
+
+				printDryRun(dir, getTokenEnv(token), args)
+				return
+			}
+
+			err := startDetachedWithStartSignaling(
+				o.opn,
+				desktopId,
+				token,
+				term,
+				termOpts,
+				arguments,
+			)
+			if err != nil {
+				log.Fatalf("Error starting command '%s': %v\n", arguments, err)
+			}
 			return
 		}
+
+		arguments = term.args(termOpts, arguments)
 	}
 
-	eCmd := exec.Command(arguments[0], arguments[1:]...)
-	eCmd.SysProcAttr = &syscall.SysProcAttr{
-		Setsid: true, // Start new session
+	arguments = getLauncher().wrap(desktopId, getTokenEnv(token), arguments)
+	if o.dryRun {
+		printDryRun(dir, getTokenEnv(token), arguments)
+		return
 	}
 
-	err := eCmd.Start()
+	err := o.opn.StartDetached(dir, getLaunchEnv(token), arguments)
 	if err != nil {
 		log.Fatalf("Error starting command '%s': %v\n", arguments, err)
 	}
+}
 
-	err = eCmd.Process.Release()
-	if err != nil {
-		log.Fatalf("Failed to release process: %v\n", err)
+// terminalClosesOnExit returns true if the terminal opn is running in closes when opn exits.
+// OPN_TERMINAL_CLOSES overrides the detection.
+func terminalClosesOnExit() bool {
+	envVal := os.Getenv("OPN_TERMINAL_CLOSES")
+	switch envVal {
+	case "", "auto":
+		return util.TerminalClosesOnExit()
+	case "always":
+		return true
+	case "never":
+		return false
+	default:
+		log.Fatalf(
+			"Unknown OPN_TERMINAL_CLOSES: '%s'. Expected 'auto', 'always', or 'never'",
+			envVal,
+		)
+		return false
 	}
 }
 
-// startDetachedWithStartSignaling will start a terminal program in a new terminal.
-// See the description in openWithSignalCmd.
-func startDetachedWithStartSignaling(terminalArgs []string, launchArgs []string) {
-	fifoPath, err := createFifo()
+// startDetachedWithStartSignaling will start a terminal program in a new terminal and wait until
+// it has started. See the description in openWithSignalCmd.
+func startDetachedWithStartSignaling(
+	opn *opnlib.Opn,
+	desktopId string,
+	token string,
+	term terminal,
+	termOpts terminalOpts,
+	launchArgs []string,
+) error {
+	fifoDir, fifoPath, err := createFifo()
 	if err != nil {
-		log.Fatalf("Error creating FIFO: %v\n", err)
+		return err
 	}
+	defer os.RemoveAll(fifoDir)
 
-	selfExe, err := os.Executable()
+	// Opened before starting the terminal so that the helper never waits for opn
+	fifo, err := openStartSignalReader(fifoPath)
 	if err != nil {
-		log.Fatalf("Failed to determine opn's path: %v\n", err)
-	}
-
-	terminalProgram := terminalArgs[0]
-	args := make([]string, 0, len(terminalArgs)+len(launchArgs)+2)
-	args = append(args, terminalArgs[1:]...)
-	args = append(args, selfExe, "openwithsig", fifoPath)
-	args = append(args, launchArgs...)
-
-	eCmd := exec.Command(terminalProgram, args...)
-	eCmd.SysProcAttr = &syscall.SysProcAttr{
-		Setsid: true, // Start new session
+		return fmt.Errorf("failed to open FIFO: %w", err)
 	}
+	defer fifo.Close()
 
-	err = eCmd.Start()
+	args, err := getStartSignalingArgs(desktopId, token, term, termOpts, fifoPath, launchArgs)
 	if err != nil {
-		log.Fatalf("Error starting command '%s': %v\n", launchArgs, err)
+		return err
 	}
 
-	fifo, err := os.Open(fifoPath)
+	err = opn.StartDetached(termOpts.dir, getLaunchEnv(token), args)
 	if err != nil {
-		log.Printf("failed to open fifo file: %v\n", err)
+		return fmt.Errorf("failed to start the terminal '%s': %w", args, err)
 	}
-	defer fifo.Close()
 
-	buf := make([]byte, 1)
-	if _, err := fifo.Read(buf); err != nil {
-		log.Printf("Failed to receive start signal: %v\n", err)
+	err = waitForStartSignal(fifo)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		log.Printf(
+			"The terminal did not report that '%s' started within %s, not waiting any longer\n",
+			launchArgs,
+			startSignalTimeout,
+		)
+		return nil
 	}
 
-	err = eCmd.Process.Release()
-	if err != nil {
-		log.Fatalf("Failed to release process: %v\n", err)
-	}
+	return err
 }
 
-func createFifo() (string, error) {
-	var fifoPath string
-existsLoop:
-	for i := 0; i < 5; i++ {
-		filename := util.RandString(10)
-		testPath := filepath.Join(os.TempDir(), filename)
-		err := syscall.Mkfifo(testPath, 0600)
-		switch {
-		case err == nil:
-			fifoPath = testPath
-			break existsLoop
-		case errors.Is(err, os.ErrExist):
-			continue
-		default:
-			return "", fmt.Errorf("Error creating fifo: %w\n", err)
-		}
+// getStartSignalingArgs returns the command that starts the terminal, which runs the program using
+// the openwithsig helper.
+func getStartSignalingArgs(
+	desktopId string,
+	token string,
+	term terminal,
+	termOpts terminalOpts,
+	fifoPath string,
+	launchArgs []string,
+) ([]string, error) {
+	selfExe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine opn's path: %w", err)
 	}
 
-	if fifoPath == "" {
-		return "", fmt.Errorf("failed to create fifo, files already exist")
+	args := make([]string, 0, len(launchArgs)+5)
+	args = append(args, selfExe, "openwithsig")
+	if termOpts.dir != "" {
+		// Not every terminal starts its command in the terminal's working directory
+		args = append(args, "--cwd", termOpts.dir)
 	}
+	args = append(args, fifoPath)
+	args = append(args, launchArgs...)
+	args = term.args(termOpts, args)
 
-	return fifoPath, nil
+	return getLauncher().wrap(desktopId, getTokenEnv(token), args), nil
 }
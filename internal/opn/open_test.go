@@ -0,0 +1,37 @@
+package opn
+
+import (
+	"github.com/MatthiasKunnen/opn/pkg/opnlib"
+	"testing"
+)
+
+func TestIsStartModeAvailable(t *testing.T) {
+	gui := opnlib.DesktopEntry{}
+	term := opnlib.DesktopEntry{Terminal: true}
+
+	tests := []struct {
+		name        string
+		multiplexer multiplexer
+		mode        StartMode
+		entry       opnlib.DesktopEntry
+		expected    bool
+	}{
+		{"attached gui", multiplexerTmux, Attached, gui, true},
+		{"detached gui", multiplexerTmux, Detached, gui, true},
+		{"window gui", multiplexerTmux, Window, gui, false},
+		{"pane gui", multiplexerKitty, Pane, gui, false},
+		{"window terminal", multiplexerTmux, Window, term, true},
+		{"pane terminal", multiplexerWezterm, Pane, term, true},
+		{"window unsupported", multiplexerZellij, Window, term, false},
+		{"pane outside multiplexer", multiplexerNone, Pane, term, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &opener{multiplexer: tt.multiplexer}
+			if got := o.isStartModeAvailable(tt.mode, tt.entry); got != tt.expected {
+				t.Errorf("expected %t, got %t", tt.expected, got)
+			}
+		})
+	}
+}
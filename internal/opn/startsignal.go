@@ -0,0 +1,110 @@
+package opn
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/xdg/basedir"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// startSignalTimeout is how long opn waits for the openwithsig helper to start the program.
+const startSignalTimeout = 10 * time.Second
+
+// The openwithsig helper writes startSignalOk to the FIFO once the program has started, or
+// startSignalError followed by the error message if it failed to start.
+const (
+	startSignalOk    = "1"
+	startSignalError = "E"
+)
+
+// maxStartSignalSize is PIPE_BUF on Linux. Writes of at most PIPE_BUF bytes to a FIFO are atomic.
+const maxStartSignalSize = 4096
+
+// getFifoParent returns the directory the FIFO directory is created in, XDG_RUNTIME_DIR or the
+// temporary directory if it is not set.
+func getFifoParent() string {
+	if basedir.RuntimeDir == "" {
+		return os.TempDir()
+	}
+
+	return basedir.RuntimeDir
+}
+
+// createFifo creates a FIFO in a new private directory under getFifoParent. The caller must remove
+// the directory.
+func createFifo() (string, string, error) {
+	dir, err := os.MkdirTemp(getFifoParent(), "opn-*") // Created with mode 0700
+	if err != nil {
+		return "", "", fmt.Errorf("error creating directory for fifo: %w", err)
+	}
+
+	fifoPath := filepath.Join(dir, "start")
+	err = syscall.Mkfifo(fifoPath, 0600)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", "", fmt.Errorf("error creating fifo: %w", err)
+	}
+
+	return dir, fifoPath, nil
+}
+
+// openStartSignalReader opens the FIFO for reading. It is opened for writing as well so that
+// opening does not block and reading does not return EOF before the helper has opened it.
+func openStartSignalReader(fifoPath string) (*os.File, error) {
+	return os.OpenFile(fifoPath, os.O_RDWR, 0)
+}
+
+// waitForStartSignal waits until the helper reports that the program has started.
+// An error wrapping os.ErrDeadlineExceeded is returned if no signal arrived in time.
+func waitForStartSignal(fifo *os.File) error {
+	err := fifo.SetReadDeadline(time.Now().Add(startSignalTimeout))
+	if err != nil {
+		return fmt.Errorf("failed to set fifo deadline: %w", err)
+	}
+
+	buf := make([]byte, maxStartSignalSize)
+	n, err := fifo.Read(buf)
+	if err != nil {
+		return fmt.Errorf("failed to receive start signal: %w", err)
+	}
+
+	signal := string(buf[:n])
+	switch {
+	case signal == startSignalOk:
+		return nil
+	case len(signal) > 0 && signal[:1] == startSignalError:
+		return errors.New(signal[1:])
+	default:
+		return fmt.Errorf("invalid start signal: %q", signal)
+	}
+}
+
+// OpenStartSignalWriter opens the FIFO created by opn for writing. It fails rather than blocks if
+// opn has stopped waiting.
+func OpenStartSignalWriter(fifoPath string) (*os.File, error) {
+	return os.OpenFile(fifoPath, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+}
+
+// SendStartSignal tells opn that the program has started, or why it failed to start if startErr
+// is not nil.
+func SendStartSignal(fifo *os.File, startErr error) error {
+	signal := startSignalOk
+	if startErr != nil {
+		signal = startSignalError + startErr.Error()
+		if len(signal) > maxStartSignalSize {
+			// Cut before the rune that does not fit so that the message remains valid UTF-8
+			end := maxStartSignalSize
+			for end > 0 && !utf8.RuneStart(signal[end]) {
+				end--
+			}
+			signal = signal[:end]
+		}
+	}
+
+	_, err := fifo.Write([]byte(signal))
+	return err
+}
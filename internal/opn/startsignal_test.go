@@ -0,0 +1,43 @@
+package opn
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSendStartSignalTruncatesOnRuneBoundary(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// The é does not fit, the signal is prefixed by a single byte
+	message := strings.Repeat("a", maxStartSignalSize-2) + "é"
+	if err := SendStartSignal(w, errors.New(message)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = waitForStartSignal(r)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	got := err.Error()
+	if want := message[:maxStartSignalSize-2]; got != want {
+		t.Errorf(
+			"expected %d bytes of a, got %d bytes ending in %q",
+			len(want),
+			len(got),
+			got[len(got)-2:],
+		)
+	}
+
+	if !utf8.ValidString(got) {
+		t.Error("expected valid UTF-8")
+	}
+}
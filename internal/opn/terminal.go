@@ -0,0 +1,233 @@
+package opn
+
+import (
+	"errors"
+	"github.com/MatthiasKunnen/opn/pkg/opnlib"
+	"github.com/MatthiasKunnen/xdg/desktop"
+	"github.com/mattn/go-shellwords"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// terminalProfile describes the options a terminal emulator accepts. An option ending with = is
+// joined with its value, other options are passed as a separate argument. Empty options are not
+// supported by the terminal.
+type terminalProfile struct {
+	// execArg precedes the command to run, e.g. -e. If empty, the command is appended directly.
+	execArg  string
+	appIdArg string
+	titleArg string
+	dirArg   string
+}
+
+// knownTerminals holds the profiles of common terminals, in the order they are tried when no
+// terminal is configured.
+var knownTerminals = []struct {
+	command []string
+	profile terminalProfile
+}{
+	{
+		command: []string{"foot"},
+		profile: terminalProfile{
+			appIdArg: "--app-id=",
+			titleArg: "--title=",
+			dirArg:   "--working-directory=",
+		},
+	},
+	{
+		command: []string{"kitty"},
+		profile: terminalProfile{
+			appIdArg: "--class=",
+			titleArg: "--title=",
+			dirArg:   "--directory=",
+		},
+	},
+	{
+		command: []string{"alacritty"},
+		profile: terminalProfile{
+			execArg:  "-e",
+			appIdArg: "--class",
+			titleArg: "--title",
+			dirArg:   "--working-directory",
+		},
+	},
+	{
+		command: []string{"wezterm", "start"},
+		profile: terminalProfile{
+			execArg:  "--",
+			appIdArg: "--class",
+			dirArg:   "--cwd",
+		},
+	},
+	{
+		command: []string{"gnome-terminal"},
+		profile: terminalProfile{
+			execArg:  "--",
+			titleArg: "--title=",
+			dirArg:   "--working-directory=",
+		},
+	},
+	{
+		command: []string{"konsole"},
+		profile: terminalProfile{
+			execArg: "-e",
+			dirArg:  "--workdir",
+		},
+	},
+	{
+		command: []string{"xterm"},
+		profile: terminalProfile{
+			execArg:  "-e",
+			appIdArg: "-class",
+			titleArg: "-title",
+		},
+	},
+}
+
+// terminal is a terminal emulator that can run a command.
+type terminal struct {
+	command []string
+	profile terminalProfile
+}
+
+// terminalOpts holds the optional settings of the terminal window. Settings that the terminal does
+// not support are ignored.
+type terminalOpts struct {
+	appId string
+	title string
+	dir   string
+}
+
+// args returns the command that runs the given command in the terminal.
+func (t terminal) args(opts terminalOpts, arguments []string) []string {
+	result := append([]string{}, t.command...)
+	result = appendTerminalOption(result, t.profile.appIdArg, opts.appId)
+	result = appendTerminalOption(result, t.profile.titleArg, opts.title)
+	result = appendTerminalOption(result, t.profile.dirArg, opts.dir)
+	if t.profile.execArg != "" {
+		result = append(result, t.profile.execArg)
+	}
+
+	return append(result, arguments...)
+}
+
+func appendTerminalOption(args []string, option string, value string) []string {
+	switch {
+	case option == "", value == "":
+		return args
+	case strings.HasSuffix(option, "="):
+		return append(args, option+value)
+	default:
+		return append(args, option, value)
+	}
+}
+
+// terminalEnvVars configure the terminal command, in order of priority.
+var terminalEnvVars = []string{"OPN_TERM_CMD", "TERMINAL_COMMAND"}
+
+// getTerminal returns the terminal to start terminal applications in. In order of priority:
+//  1. The command in one of terminalEnvVars, the arguments are appended as is.
+//  2. The first installed terminal of the xdg-terminals.list files.
+//  3. The first installed terminal of knownTerminals.
+func getTerminal(opn *opnlib.Opn) (terminal, bool) {
+	for _, envVar := range terminalEnvVars {
+		envVal := os.Getenv(envVar)
+		if envVal == "" {
+			continue
+		}
+
+		parsedArgs, err := shellwords.Parse(envVal)
+		if err != nil || len(parsedArgs) == 0 {
+			log.Fatalf("Failed to parse %s=%s: %v", envVar, envVal, err)
+		}
+
+		return terminal{command: parsedArgs}, true
+	}
+
+	desktopIds, err := opnlib.GetPreferredTerminals(os.Getenv("XDG_CURRENT_DESKTOP"))
+	if err != nil {
+		log.Printf("Failed to read the preferred terminals: %v\n", err)
+	}
+
+	for _, desktopId := range desktopIds {
+		t, err := getDesktopEntryTerminal(opn, desktopId)
+		if err != nil {
+			log.Printf("Skipping terminal %s: %v\n", desktopId, err)
+			continue
+		}
+
+		return t, true
+	}
+
+	for _, known := range knownTerminals {
+		if _, err := exec.LookPath(known.command[0]); err == nil {
+			return terminal{command: known.command, profile: known.profile}, true
+		}
+	}
+
+	return terminal{}, false
+}
+
+// getDesktopEntryTerminal returns the terminal started by the desktop entry. The options are taken
+// from the X-TerminalArg* keys, falling back to the known profile of the terminal and the defaults
+// of the spec.
+func getDesktopEntryTerminal(opn *opnlib.Opn, desktopId string) (terminal, error) {
+	entry, err := opn.GetDesktopEntry(desktopId)
+	if err != nil {
+		return terminal{}, err
+	}
+
+	// NoDisplay only hides the terminal from menus, it can still be used
+	entry.NoDisplay = false
+	if reason := entry.HiddenReason(os.Getenv("XDG_CURRENT_DESKTOP")); reason != "" {
+		return terminal{}, errors.New(reason)
+	}
+
+	command := entry.Exec.ToArguments(desktop.FieldCodeProvider{
+		GetDesktopFileLocation: func() string { return entry.FilePath },
+		GetFile:                func() string { return "" },
+		GetFiles:               func() []string { return nil },
+		GetName:                func() string { return entry.Name.Default },
+		GetUrl:                 func() string { return "" },
+		GetUrls:                func() []string { return nil },
+	})
+	if len(command) == 0 {
+		return terminal{}, errors.New("it has no Exec value")
+	}
+
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return terminal{}, err
+	}
+
+	profile := terminalProfile{execArg: "-e"}
+	for _, known := range knownTerminals {
+		if filepath.Base(command[0]) == known.command[0] {
+			profile = known.profile
+			if len(command) == 1 {
+				command = append(command, known.command[1:]...)
+			}
+			break
+		}
+	}
+
+	terminalArgs, err := opnlib.GetTerminalArgs(entry.FilePath)
+	if err != nil {
+		return terminal{}, err
+	}
+
+	for key, arg := range map[string]*string{
+		opnlib.TerminalArgExec:  &profile.execArg,
+		opnlib.TerminalArgAppId: &profile.appIdArg,
+		opnlib.TerminalArgTitle: &profile.titleArg,
+		opnlib.TerminalArgDir:   &profile.dirArg,
+	} {
+		if value, ok := terminalArgs[key]; ok {
+			*arg = value
+		}
+	}
+
+	return terminal{command: command, profile: profile}, nil
+}
@@ -2,38 +2,212 @@ package util
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 )
 
-// ParentIsShell returns true if the parent process is a shell.
-// If this could not be determined, it will return false.
-func ParentIsShell() bool {
-	ppid := os.Getppid()
+// knownShells holds shells that might not be listed in /etc/shells, e.g. because they were
+// installed using Nix.
+var knownShells = map[string]bool{
+	"ash": true, "bash": true, "csh": true, "dash": true, "elvish": true, "fish": true,
+	"ksh": true, "mksh": true, "nu": true, "oksh": true, "sh": true, "tcsh": true, "xonsh": true,
+	"yash": true, "zsh": true,
+}
+
+// wrappers holds programs that run a command and exit when it does.
+var wrappers = map[string]bool{
+	"chrt": true, "doas": true, "env": true, "ionice": true, "ltrace": true, "nice": true,
+	"nohup": true, "run0": true, "stdbuf": true, "strace": true, "su": true, "sudo": true,
+	"taskset": true, "time": true, "timeout": true,
+}
+
+// valueOptions holds the options of shells that take the next argument as value. Short options
+// are listed without prefix, they take a value both when prefixed by - and +.
+var valueOptions = map[string][]string{
+	"ash":  {"o"},
+	"bash": {"o", "O", "--rcfile", "--init-file"},
+	"dash": {"o"},
+	"fish": {"C", "--init-command"},
+	"ksh":  {"o"},
+	"mksh": {"o"},
+	"nu":   {"--config", "--env-config"},
+	"oksh": {"o"},
+	"sh":   {"o"},
+	"yash": {"o"},
+	"zsh":  {"o"},
+}
+
+// procStat holds the fields of /proc/<pid>/stat that are used.
+type procStat struct {
+	ppid    int
+	session int
+	ttyNr   int
+}
+
+// TerminalClosesOnExit returns true if the terminal opn is running in is expected to close when
+// opn exits, e.g. when started using "foot opn file x".
+// The ancestors of opn in its session are walked up to the session leader. The terminal stays
+// open if one of them is an interactive shell or a program other than a wrapper like sudo or
+// "sh -c", since it keeps running after opn exits. If opn has no controlling terminal, false is
+// returned. If the ancestors could not be determined, true is returned as waiting for the
+// program to start is harmless while exiting early could take it out.
+func TerminalClosesOnExit() bool {
+	return terminalClosesOnExit("/proc", "/etc/shells", os.Getpid())
+}
 
-	procExePath := fmt.Sprintf("/proc/%d/exe", ppid)
-	parentExePath, err := os.Readlink(procExePath)
+func terminalClosesOnExit(procRoot string, shellsFile string, pid int) bool {
+	self, err := readProcStat(procRoot, pid)
 	if err != nil {
+		return true
+	}
+
+	if self.ttyNr == 0 {
 		return false
 	}
 
-	shellsFile, err := os.Open("/etc/shells")
-	if err != nil {
+	// The session leader owns the controlling terminal, the terminal closes after it exits
+	stat := self
+	for pid != self.session {
+		parent := stat.ppid
+		if parent <= 1 {
+			return true // The session leader is not an ancestor
+		}
+
+		parentStat, err := readProcStat(procRoot, parent)
+		if err != nil || parentStat.session != self.session || parentStat.ttyNr != self.ttyNr {
+			return true
+		}
+
+		if !exitsWithChild(procRoot, shellsFile, parent) {
+			return false
+		}
+
+		pid, stat = parent, parentStat
+	}
+
+	return true
+}
+
+// exitsWithChild returns true if the process is a wrapper or a non-interactive shell, i.e. it exits
+// when its child exits. If the command line cannot be read, true is returned.
+func exitsWithChild(procRoot string, shellsFile string, pid int) bool {
+	cmdline, err := os.ReadFile(path.Join(procRoot, strconv.Itoa(pid), "cmdline"))
+	if err != nil || len(cmdline) == 0 {
+		return true
+	}
+
+	args := strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+	if strings.HasPrefix(args[0], "-") {
+		return false // Login shell
+	}
+
+	name := path.Base(args[0])
+	if exe, err := os.Readlink(path.Join(procRoot, strconv.Itoa(pid), "exe")); err == nil {
+		if isShell(exe, shellsFile) {
+			name = path.Base(exe)
+		}
+	}
+
+	if wrappers[name] {
+		return true
+	}
+
+	if !isShell(name, shellsFile) {
 		return false
 	}
-	defer shellsFile.Close()
 
-	scanner := bufio.NewScanner(shellsFile)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == parentExePath {
+	// Options end at the first other argument, the script or the command of -c
+	options := valueOptions[name]
+	command := false
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--command", arg == "--commands":
+			command = true
+		case arg == "--", arg == "-":
+			return command || i+1 < len(args)
+		case slices.Contains(options, arg):
+			i++ // E.g. --rcfile file
+		case strings.HasPrefix(arg, "--"):
+		case strings.HasPrefix(arg, "-"), strings.HasPrefix(arg, "+"):
+			for _, letter := range arg[1:] {
+				switch {
+				case letter == 'c' && arg[0] == '-':
+					command = true
+				case slices.Contains(options, string(letter)):
+					i++ // E.g. -o vi
+				}
+			}
+		default:
 			return true
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	return command
+}
+
+// isShell returns true if the program name or path belongs to a known shell or is listed in the
+// shells file, e.g. /etc/shells.
+func isShell(program string, shellsFile string) bool {
+	if knownShells[path.Base(program)] {
+		return true
+	}
+
+	file, err := os.Open(shellsFile)
+	if err != nil {
 		return false
 	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == program || (!strings.Contains(program, "/") && path.Base(line) == program) {
+			return true
+		}
+	}
 
 	return false
 }
+
+// readProcStat reads /proc/<pid>/stat, see proc_pid_stat(5).
+func readProcStat(procRoot string, pid int) (procStat, error) {
+	content, err := os.ReadFile(path.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, err
+	}
+
+	// The command name can contain spaces and parentheses
+	commEnd := bytes.LastIndexByte(content, ')')
+	if commEnd == -1 {
+		return procStat{}, fmt.Errorf("invalid stat of process %d", pid)
+	}
+
+	// Starting from the state: state ppid pgrp session tty_nr
+	fields := strings.Fields(string(content[commEnd+1:]))
+	if len(fields) < 5 {
+		return procStat{}, fmt.Errorf("invalid stat of process %d", pid)
+	}
+
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procStat{}, fmt.Errorf("invalid ppid of process %d: %w", pid, err)
+	}
+
+	session, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return procStat{}, fmt.Errorf("invalid session of process %d: %w", pid, err)
+	}
+
+	ttyNr, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return procStat{}, fmt.Errorf("invalid tty_nr of process %d: %w", pid, err)
+	}
+
+	return procStat{ppid: ppid, session: session, ttyNr: ttyNr}, nil
+}
@@ -0,0 +1,266 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testTty = 34816
+
+// testProc describes a process of a fake /proc.
+type testProc struct {
+	pid     int
+	ppid    int
+	session int
+	tty     int
+	cmdline []string
+	exe     string
+}
+
+// writeProcs creates a fake /proc containing the processes and returns its path.
+func writeProcs(t *testing.T, procs []testProc) string {
+	t.Helper()
+	procRoot := t.TempDir()
+	for _, proc := range procs {
+		dir := filepath.Join(procRoot, strconv.Itoa(proc.pid))
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		comm := filepath.Base(proc.cmdline[0])
+		stat := fmt.Sprintf(
+			"%d (%s) S %d %d %d %d -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0\n",
+			proc.pid, comm, proc.ppid, proc.pid, proc.session, proc.tty,
+		)
+		if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		cmdline := strings.Join(proc.cmdline, "\x00") + "\x00"
+		if err := os.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdline), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if proc.exe != "" {
+			if err := os.Symlink(proc.exe, filepath.Join(dir, "exe")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return procRoot
+}
+
+// opnProc returns the opn process with the given parent and session.
+func opnProc(pid int, ppid int, session int) testProc {
+	return testProc{
+		pid:     pid,
+		ppid:    ppid,
+		session: session,
+		tty:     testTty,
+		cmdline: []string{"opn", "file", "x"},
+		exe:     "/usr/bin/opn",
+	}
+}
+
+// leaderProc returns the session leader started by the terminal with the given command line.
+func leaderProc(pid int, cmdline ...string) testProc {
+	return testProc{pid: pid, ppid: 99, session: pid, tty: testTty, cmdline: cmdline}
+}
+
+func TestTerminalClosesOnExit(t *testing.T) {
+	shellsFile := filepath.Join(t.TempDir(), "shells")
+	err := os.WriteFile(shellsFile, []byte("# /etc/shells\n/bin/bash\n/usr/bin/rbash\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		procs []testProc
+		want  bool
+	}{
+		{
+			name:  "foot runs opn",
+			procs: []testProc{opnProc(100, 99, 100)},
+			want:  true,
+		},
+		{
+			name: "foot runs sh -c opn",
+			procs: []testProc{
+				leaderProc(100, "sh", "-c", "opn file x"),
+				opnProc(101, 100, 100),
+			},
+			want: true,
+		},
+		{
+			name: "interactive bash",
+			procs: []testProc{
+				leaderProc(100, "bash"),
+				opnProc(101, 100, 100),
+			},
+			want: false,
+		},
+		{
+			name: "interactive bash with -i",
+			procs: []testProc{
+				leaderProc(100, "/bin/bash", "-i"),
+				opnProc(101, 100, 100),
+			},
+			want: false,
+		},
+		{
+			name: "login shell",
+			procs: []testProc{
+				leaderProc(100, "-bash"),
+				opnProc(101, 100, 100),
+			},
+			want: false,
+		},
+		{
+			name: "sudo and env wrappers",
+			procs: []testProc{
+				leaderProc(100, "sh", "-c", "sudo env A=b opn file x"),
+				{pid: 101, ppid: 100, session: 100, tty: testTty, cmdline: []string{"sudo", "env"}},
+				{pid: 102, ppid: 101, session: 100, tty: testTty, cmdline: []string{"env", "A=b"}},
+				opnProc(103, 102, 100),
+			},
+			want: true,
+		},
+		{
+			name: "sudo in an interactive shell",
+			procs: []testProc{
+				leaderProc(100, "zsh"),
+				{pid: 101, ppid: 100, session: 100, tty: testTty, cmdline: []string{"sudo", "opn"}},
+				opnProc(102, 101, 100),
+			},
+			want: false,
+		},
+		{
+			name: "other program",
+			procs: []testProc{
+				leaderProc(100, "tmux"),
+				opnProc(101, 100, 100),
+			},
+			want: false,
+		},
+		{
+			name: "Nix store shell",
+			procs: []testProc{
+				leaderProc(100, "/nix/store/0123-zsh-5.9/bin/zsh", "-c", "opn file x"),
+				opnProc(101, 100, 100),
+			},
+			want: true,
+		},
+		{
+			name: "interactive Nix store shell",
+			procs: []testProc{
+				leaderProc(100, "/nix/store/0123-zsh-5.9/bin/zsh"),
+				opnProc(101, 100, 100),
+			},
+			want: false,
+		},
+		{
+			name: "shell with a different argv[0]",
+			procs: []testProc{
+				{
+					pid:     100,
+					ppid:    99,
+					session: 100,
+					tty:     testTty,
+					cmdline: []string{"launcher", "-c", "opn file x"},
+					exe:     "/nix/store/0123-bash-5.2/bin/bash",
+				},
+				opnProc(101, 100, 100),
+			},
+			want: true,
+		},
+		{
+			name: "shell listed in the shells file",
+			procs: []testProc{
+				leaderProc(100, "rbash", "-c", "opn file x"),
+				opnProc(101, 100, 100),
+			},
+			want: true,
+		},
+		{
+			name: "interactive bash with --rcfile",
+			procs: []testProc{
+				leaderProc(100, "bash", "--rcfile", "rc"),
+				opnProc(101, 100, 100),
+			},
+			want: false,
+		},
+		{
+			name: "interactive bash with -O",
+			procs: []testProc{
+				leaderProc(100, "bash", "-O", "extglob", "+o", "histexpand"),
+				opnProc(101, 100, 100),
+			},
+			want: false,
+		},
+		{
+			name: "interactive zsh with -o",
+			procs: []testProc{
+				leaderProc(100, "zsh", "-o", "vi"),
+				opnProc(101, 100, 100),
+			},
+			want: false,
+		},
+		{
+			name: "bash script with options",
+			procs: []testProc{
+				leaderProc(100, "bash", "-O", "extglob", "script.sh", "-i"),
+				opnProc(101, 100, 100),
+			},
+			want: true,
+		},
+		{
+			name: "session mismatch",
+			procs: []testProc{
+				leaderProc(100, "bash"),
+				{pid: 101, ppid: 100, session: 100, tty: testTty, cmdline: []string{"sudo", "opn"}},
+				// E.g. sudo with use_pty, opn runs in the session of a new pseudo-terminal
+				{pid: 102, ppid: 101, session: 105, tty: testTty + 1, cmdline: []string{"opn"}},
+			},
+			want: true,
+		},
+		{
+			name:  "parent is init",
+			procs: []testProc{opnProc(100, 1, 50)},
+			want:  true,
+		},
+		{
+			name:  "unreadable parent",
+			procs: []testProc{opnProc(101, 100, 100)},
+			want:  true,
+		},
+		{
+			name: "no controlling terminal",
+			procs: []testProc{
+				{pid: 100, ppid: 1, session: 100, cmdline: []string{"opn"}},
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procRoot := writeProcs(t, tt.procs)
+			pid := tt.procs[len(tt.procs)-1].pid
+			if got := terminalClosesOnExit(procRoot, shellsFile, pid); got != tt.want {
+				t.Errorf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("unreadable stat", func(t *testing.T) {
+		if !terminalClosesOnExit(t.TempDir(), shellsFile, 100) {
+			t.Error("expected true")
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package opnlib
+
+import (
+	"slices"
+	"strings"
+)
+
+// AssociationSource describes the association of a desktop ID with a MIME type.
+type AssociationSource struct {
+	Mime string
+
+	// Rank is the position of the desktop ID in the list of applications associated with the
+	// MIME type. 0 is the default application.
+	Rank int
+
+	// File is the mimeapps.list file, or the desktop file, that caused the association.
+	File string
+
+	// Section is the mimeapps.list section that caused the association or SectionMimeType if
+	// the association comes from the MimeType key of the desktop file.
+	Section string
+}
+
+// GetAssociationsOfDesktopId returns, for every MIME type the desktop ID is associated with, its
+// rank and the origin of the association. The result is sorted by MIME type.
+// The mimeapps.list files are read from the file system and merged with the desktop entries of the
+// index in the same way as when the index is generated.
+func (opn *Opn) GetAssociationsOfDesktopId(desktopId string) []AssociationSource {
+	remote, ok, _ := fromDaemon[[]AssociationSource](opn, "GetAssociationsOfDesktopId", desktopId)
+	if ok {
+		return remote
+	}
+
+	opn.index.materialize()
+	merged := opn.index.mergeAssociations(getCurrentMimeappsListPaths())
+
+	result := make([]AssociationSource, 0)
+	for mime, desktopIds := range merged.associations {
+		rank := slices.Index(desktopIds, desktopId)
+		if rank == -1 {
+			continue
+		}
+
+		origin := merged.origins[mime][desktopId]
+		result = append(result, AssociationSource{
+			Mime:    opn.index.MimeInfo.spelling(mime),
+			Rank:    rank,
+			File:    origin.file,
+			Section: origin.section,
+		})
+	}
+
+	slices.SortFunc(result, func(a, b AssociationSource) int {
+		return strings.Compare(a.Mime, b.Mime)
+	})
+
+	return result
+}
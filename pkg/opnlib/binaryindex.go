@@ -0,0 +1,382 @@
+package opnlib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"syscall"
+)
+
+// The binary index format allows looking up single keys without decoding the whole index. The
+// file is memory-mapped and only the pages that are needed for a lookup are read.
+//
+// All integers are unsigned 32-bit little endian. The layout is:
+//   - magic: binaryIndexMagic
+//   - version: IndexVersion
+//   - meta length, followed by the JSON encoded Index without the fields stored in tables
+//   - associations table length, followed by the associations table
+//   - desktop ID table length, followed by the desktop ID table
+//   - desktop entry table length, followed by the desktop entry table. Its values consist of a
+//     single JSON encoded DesktopEntry.
+//
+// A table maps a string to a list of strings and is laid out as follows:
+//   - count, the amount of entries
+//   - count offsets, one per entry, relative to the start of the entries. The entries are sorted
+//     by key which allows for a binary search.
+//   - entries, each entry consists of the key length followed by the key, and the value count
+//     followed, for each value, by its length and the value.
+var binaryIndexMagic = []byte("opnindex")
+
+var errCorruptBinaryIndex = errors.New("corrupt binary index")
+
+// binaryTable is a read-only, sorted, string to string list map backed by a byte slice.
+type binaryTable struct {
+	count   int
+	offsets []byte
+	entries []byte
+}
+
+// parseBinaryTable validates the table header and returns the table.
+func parseBinaryTable(data []byte) (binaryTable, error) {
+	if len(data) < 4 {
+		return binaryTable{}, errCorruptBinaryIndex
+	}
+
+	count := int(binary.LittleEndian.Uint32(data))
+	offsetsEnd := 4 + count*4
+	if count < 0 || offsetsEnd > len(data) {
+		return binaryTable{}, errCorruptBinaryIndex
+	}
+
+	return binaryTable{
+		count:   count,
+		offsets: data[4:offsetsEnd],
+		entries: data[offsetsEnd:],
+	}, nil
+}
+
+// readBytes reads a length-prefixed byte slice at the given position. It returns the slice and the
+// position after it or false if the data is out of bounds.
+func readBytes(data []byte, pos int) ([]byte, int, bool) {
+	if pos < 0 || pos+4 > len(data) {
+		return nil, 0, false
+	}
+
+	length := int(binary.LittleEndian.Uint32(data[pos:]))
+	start := pos + 4
+	end := start + length
+	if length < 0 || end > len(data) {
+		return nil, 0, false
+	}
+
+	return data[start:end], end, true
+}
+
+// keyAt returns the key of the i-th entry and the position of its values.
+func (t binaryTable) keyAt(i int) ([]byte, int, bool) {
+	offset := int(binary.LittleEndian.Uint32(t.offsets[i*4:]))
+	return readBytes(t.entries, offset)
+}
+
+// valuesAt decodes the values starting at the given position.
+func (t binaryTable) valuesAt(pos int) ([]string, bool) {
+	if pos+4 > len(t.entries) {
+		return nil, false
+	}
+
+	count := int(binary.LittleEndian.Uint32(t.entries[pos:]))
+	pos += 4
+	if count < 0 || count > len(t.entries) {
+		return nil, false
+	}
+
+	result := make([]string, 0, count)
+	for range count {
+		var value []byte
+		var ok bool
+		value, pos, ok = readBytes(t.entries, pos)
+		if !ok {
+			return nil, false
+		}
+
+		result = append(result, string(value))
+	}
+
+	return result, true
+}
+
+// lookup returns the values of the key using a binary search.
+func (t binaryTable) lookup(key string) ([]string, bool) {
+	keyBytes := []byte(key)
+	corrupt := false
+	i := sort.Search(t.count, func(i int) bool {
+		k, _, ok := t.keyAt(i)
+		if !ok {
+			corrupt = true
+			return true
+		}
+
+		return bytes.Compare(k, keyBytes) >= 0
+	})
+
+	if corrupt || i >= t.count {
+		return nil, false
+	}
+
+	k, pos, _ := t.keyAt(i)
+	if !bytes.Equal(k, keyBytes) {
+		return nil, false
+	}
+
+	return t.valuesAt(pos)
+}
+
+// all decodes the whole table. Corrupt entries are skipped.
+func (t binaryTable) all() map[string][]string {
+	result := make(map[string][]string, t.count)
+	for i := range t.count {
+		k, pos, ok := t.keyAt(i)
+		if !ok {
+			continue
+		}
+
+		values, ok := t.valuesAt(pos)
+		if !ok {
+			continue
+		}
+
+		result[string(k)] = values
+	}
+
+	return result
+}
+
+// lazyIndex holds the tables of an index loaded from a binary file.
+type lazyIndex struct {
+	associations   binaryTable
+	desktopIds     binaryTable
+	desktopEntries binaryTable
+
+	// data is the memory-mapped file.
+	data []byte
+}
+
+// lookupDesktopEntry decodes the desktop entry of the desktop ID.
+func (l *lazyIndex) lookupDesktopEntry(desktopId string) (DesktopEntry, bool) {
+	values, ok := l.desktopEntries.lookup(desktopId)
+	if !ok || len(values) != 1 {
+		return DesktopEntry{}, false
+	}
+
+	var entry DesktopEntry
+	if err := json.Unmarshal([]byte(values[0]), &entry); err != nil {
+		return DesktopEntry{}, false
+	}
+
+	return entry, true
+}
+
+// allDesktopEntries decodes all desktop entries. Corrupt entries are skipped.
+func (l *lazyIndex) allDesktopEntries() map[string]DesktopEntry {
+	result := make(map[string]DesktopEntry, l.desktopEntries.count)
+	for desktopId, values := range l.desktopEntries.all() {
+		if len(values) != 1 {
+			continue
+		}
+
+		var entry DesktopEntry
+		if err := json.Unmarshal([]byte(values[0]), &entry); err == nil {
+			result[desktopId] = entry
+		}
+	}
+
+	return result
+}
+
+func appendUint32(buf []byte, v int) []byte {
+	return binary.LittleEndian.AppendUint32(buf, uint32(v))
+}
+
+func appendBytes(buf []byte, b string) []byte {
+	buf = appendUint32(buf, len(b))
+	return append(buf, b...)
+}
+
+// encodeBinaryTable encodes the map as a table.
+func encodeBinaryTable(m map[string][]string) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	offsets := make([]byte, 0, 4+len(keys)*4)
+	offsets = appendUint32(offsets, len(keys))
+	var entries []byte
+	for _, key := range keys {
+		offsets = appendUint32(offsets, len(entries))
+		entries = appendBytes(entries, key)
+		entries = appendUint32(entries, len(m[key]))
+		for _, value := range m[key] {
+			entries = appendBytes(entries, value)
+		}
+	}
+
+	return append(offsets, entries...)
+}
+
+// writeBinaryIndex writes the index in the binary format.
+func writeBinaryIndex(w io.Writer, index *Index) error {
+	index.materialize()
+
+	meta := *index
+	meta.Associations = nil
+	meta.DesktopIdToPaths = nil
+	meta.DesktopEntries = nil
+	metaJson, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	desktopEntries := make(map[string][]string, len(index.DesktopEntries))
+	for desktopId, entry := range index.DesktopEntries {
+		entryJson, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		desktopEntries[desktopId] = []string{string(entryJson)}
+	}
+
+	buf := slices.Clone(binaryIndexMagic)
+	buf = appendUint32(buf, IndexVersion)
+	buf = appendBytes(buf, string(metaJson))
+	buf = appendBytes(buf, string(encodeBinaryTable(index.Associations)))
+	buf = appendBytes(buf, string(encodeBinaryTable(index.DesktopIdToPaths)))
+	buf = appendBytes(buf, string(encodeBinaryTable(desktopEntries)))
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// loadBinaryIndex memory-maps the binary index file. Only the metadata is decoded, the associations
+// and desktop IDs are looked up when needed.
+func loadBinaryIndex(filename string) (*Index, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error loading index from '%s': %w", filename, err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error loading index from '%s': %w", filename, err)
+	}
+
+	if stat.Size() < int64(len(binaryIndexMagic)+4) {
+		return nil, fmt.Errorf(
+			"parsing error loading index from '%s': %w",
+			filename,
+			errCorruptBinaryIndex,
+		)
+	}
+
+	// The mapping stays valid after closing the file, and after the file is replaced, until the
+	// index is released.
+	data, err := syscall.Mmap(
+		int(file.Fd()),
+		0,
+		int(stat.Size()),
+		syscall.PROT_READ,
+		syscall.MAP_SHARED,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping index from '%s': %w", filename, err)
+	}
+
+	index, err := parseBinaryIndex(data)
+	if err != nil {
+		_ = syscall.Munmap(data)
+		if errors.Is(err, ErrIndexVersionMismatch) {
+			return nil, fmt.Errorf("%w: index at '%s'", err, filename)
+		}
+
+		return nil, fmt.Errorf("parsing error loading index from '%s': %w", filename, err)
+	}
+
+	return index, nil
+}
+
+func parseBinaryIndex(data []byte) (*Index, error) {
+	if !bytes.HasPrefix(data, binaryIndexMagic) {
+		return nil, errCorruptBinaryIndex
+	}
+
+	pos := len(binaryIndexMagic)
+	version := int(binary.LittleEndian.Uint32(data[pos:]))
+	if version != IndexVersion {
+		return nil, fmt.Errorf(
+			"%w: has version %d, expected %d",
+			ErrIndexVersionMismatch,
+			version,
+			IndexVersion,
+		)
+	}
+	pos += 4
+
+	metaJson, pos, ok := readBytes(data, pos)
+	if !ok {
+		return nil, errCorruptBinaryIndex
+	}
+
+	associationsData, pos, ok := readBytes(data, pos)
+	if !ok {
+		return nil, errCorruptBinaryIndex
+	}
+
+	desktopIdsData, pos, ok := readBytes(data, pos)
+	if !ok {
+		return nil, errCorruptBinaryIndex
+	}
+
+	desktopEntriesData, _, ok := readBytes(data, pos)
+	if !ok {
+		return nil, errCorruptBinaryIndex
+	}
+
+	var index Index
+	err := json.Unmarshal(metaJson, &index)
+	if err != nil {
+		return nil, err
+	}
+
+	associations, err := parseBinaryTable(associationsData)
+	if err != nil {
+		return nil, err
+	}
+
+	desktopIds, err := parseBinaryTable(desktopIdsData)
+	if err != nil {
+		return nil, err
+	}
+
+	desktopEntries, err := parseBinaryTable(desktopEntriesData)
+	if err != nil {
+		return nil, err
+	}
+
+	index.lazy = &lazyIndex{
+		associations:   associations,
+		desktopIds:     desktopIds,
+		desktopEntries: desktopEntries,
+		data:           data,
+	}
+
+	return &index, nil
+}
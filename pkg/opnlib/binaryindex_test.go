@@ -0,0 +1,80 @@
+package opnlib
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestIndex returns an index with the given amount of MIME types and desktop IDs. Every MIME
+// type is associated with a few desktop IDs.
+func newTestIndex(mimeCount int, desktopIdCount int) *Index {
+	index := &Index{
+		Version:          IndexVersion,
+		GeneratedOn:      time.Now(),
+		Associations:     make(map[string][]string, mimeCount),
+		DesktopIdToPaths: make(map[string][]string, desktopIdCount),
+		DesktopEntries:   make(map[string]DesktopEntry, desktopIdCount),
+	}
+
+	for i := range desktopIdCount {
+		desktopId := fmt.Sprintf("app%d.desktop", i)
+		filePath := "/usr/share/applications/" + desktopId
+		index.DesktopIdToPaths[desktopId] = []string{filePath}
+		index.DesktopEntries[desktopId] = DesktopEntry{
+			FilePath: filePath,
+			Name:     LocalizedString{Default: fmt.Sprintf("App %d", i)},
+		}
+	}
+
+	for i := range mimeCount {
+		mime := fmt.Sprintf("application/x-type%d", i)
+		for j := range 3 {
+			desktopId := fmt.Sprintf("app%d.desktop", (i+j)%desktopIdCount)
+			index.Associations[mime] = append(index.Associations[mime], desktopId)
+		}
+	}
+
+	return index
+}
+
+// benchmarkIndexFormats runs the lookup against an index loaded from each format. Loading is part
+// of every iteration as opn loads the index once per invocation.
+func benchmarkIndexFormats(b *testing.B, lookup func(opn *Opn)) {
+	index := newTestIndex(2000, 1000)
+	for _, filename := range []string{"db.json", "db.bin"} {
+		cachePath := filepath.Join(b.TempDir(), filename)
+		if err := index.SaveIndex(cachePath); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(filepath.Ext(filename)[1:], func(b *testing.B) {
+			for range b.N {
+				loaded, err := LoadIndex(cachePath)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				lookup(&Opn{index: loaded})
+				loaded.release()
+			}
+		})
+	}
+}
+
+func BenchmarkGetDesktopIdsForMime(b *testing.B) {
+	benchmarkIndexFormats(b, func(opn *Opn) {
+		if len(opn.GetDesktopIdsForMime("application/x-type1000")) == 0 {
+			b.Fatal("no desktop IDs found")
+		}
+	})
+}
+
+func BenchmarkGetDesktopFileLocations(b *testing.B) {
+	benchmarkIndexFormats(b, func(opn *Opn) {
+		if len(opn.GetDesktopFileLocations("app500.desktop")) == 0 {
+			b.Fatal("no desktop file locations found")
+		}
+	})
+}
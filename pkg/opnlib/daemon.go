@@ -0,0 +1,415 @@
+package opnlib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/xdg/basedir"
+	"log"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrDaemonRunning is returned when starting a daemon while another one is already running.
+var ErrDaemonRunning = errors.New("daemon is already running")
+
+const (
+	// daemonRefreshDelay is the time between detecting a change and refreshing the index. It groups
+	// changes that happen in quick succession, e.g. during the installation of a package.
+	daemonRefreshDelay = 200 * time.Millisecond
+
+	// daemonRefreshInterval is the interval at which the index is refreshed regardless of
+	// changes. This expires the index and catches changes that inotify missed.
+	daemonRefreshInterval = time.Minute
+
+	// daemonIdleTimeout is the time after which idle connections are closed.
+	daemonIdleTimeout = time.Minute
+)
+
+// Daemon keeps the index in memory and answers the lookups of other opn processes over a Unix
+// socket. The index, and the cache, are updated when the application directories, mimeapps.list
+// files, or shared-mime-info database change, or when requested using Opn.Regenerate.
+// Applications that are opened detached are started by the daemon, see Opn.StartDetached.
+//
+// The protocol consists of newline delimited JSON. Every daemonRequest is answered by exactly one
+// daemonResponse.
+type Daemon struct {
+	// CacheFilePath, see Opn.CacheFilePath.
+	CacheFilePath string
+
+	mu          sync.RWMutex
+	opn         *Opn
+	env         []string
+	maxCacheAge time.Duration
+	watcher     *inotifyWatcher
+}
+
+type daemonRequest struct {
+	Method string
+	Args   []string
+}
+
+type daemonResponse struct {
+	Result json.RawMessage `json:",omitempty"`
+	Error  string          `json:",omitempty"`
+
+	// Broken is true if Error wraps ErrBrokenDesktopEntry.
+	Broken bool `json:",omitempty"`
+}
+
+// daemonHello is the method used to check whether the daemon can be used by a client. Its
+// arguments are the daemonEnvironment of the client.
+const daemonHello = "Hello"
+
+// daemonRegenerate is the method that regenerates the index of the daemon, see Opn.Regenerate.
+const daemonRegenerate = "Regenerate"
+
+type daemonMethod struct {
+	args int
+	call func(opn *Opn, args []string) (any, error)
+}
+
+// daemonMethods contains the lookups that are answered by the daemon. The names match the methods
+// of Opn.
+var daemonMethods = map[string]daemonMethod{
+	"GetDesktopIdsForBroadMime": {1, func(opn *Opn, args []string) (any, error) {
+		return opn.GetDesktopIdsForBroadMime(args[0]), nil
+	}},
+	"GetDesktopIdsForMime": {1, func(opn *Opn, args []string) (any, error) {
+		return opn.GetDesktopIdsForMime(args[0]), nil
+	}},
+	"CanonicalMime": {1, func(opn *Opn, args []string) (any, error) {
+		return opn.CanonicalMime(args[0]), nil
+	}},
+	"GetMimesMatching": {1, func(opn *Opn, args []string) (any, error) {
+		return opn.GetMimesMatching(args[0])
+	}},
+	"GetDesktopFileLocations": {1, func(opn *Opn, args []string) (any, error) {
+		return opn.GetDesktopFileLocations(args[0]), nil
+	}},
+	"GetMimeGlobs": {0, func(opn *Opn, args []string) (any, error) {
+		return opn.GetMimeGlobs(), nil
+	}},
+	"MatchMimeGlob": {1, func(opn *Opn, args []string) (any, error) {
+		return opn.MatchMimeGlob(args[0]), nil
+	}},
+	"ExportJson": {0, func(opn *Opn, args []string) (any, error) {
+		var buf bytes.Buffer
+		err := opn.ExportJson(&buf)
+		return json.RawMessage(buf.Bytes()), err
+	}},
+	"GetDesktopIds": {0, func(opn *Opn, args []string) (any, error) {
+		return opn.GetDesktopIds(), nil
+	}},
+	"GetMimesWithDefault": {1, func(opn *Opn, args []string) (any, error) {
+		return opn.GetMimesWithDefault(args[0]), nil
+	}},
+	"GetDesktopEntry": {1, func(opn *Opn, args []string) (any, error) {
+		return opn.GetDesktopEntry(args[0])
+	}},
+	"GetDesktopEntryErrors": {0, func(opn *Opn, args []string) (any, error) {
+		return opn.GetDesktopEntryErrors(), nil
+	}},
+	"GetAssociationsOfDesktopId": {1, func(opn *Opn, args []string) (any, error) {
+		return opn.GetAssociationsOfDesktopId(args[0]), nil
+	}},
+}
+
+// GetDaemonSocketPath returns the path of the socket the daemon listens on. An empty string is
+// returned if XDG_RUNTIME_DIR is not set.
+func GetDaemonSocketPath() string {
+	if basedir.RuntimeDir == "" {
+		return ""
+	}
+
+	return path.Join(basedir.RuntimeDir, "opn/daemon.sock")
+}
+
+// daemonEnvironment returns the values that influence the index. A client only uses the daemon if
+// these are the same for both.
+func daemonEnvironment(cachePath string) []string {
+	env := []string{"cache=" + cachePath}
+	for _, key := range []string{
+		"HOME",
+		"XDG_CURRENT_DESKTOP",
+		"XDG_CONFIG_HOME",
+		"XDG_CONFIG_DIRS",
+		"XDG_DATA_HOME",
+		"XDG_DATA_DIRS",
+		"OPN_CACHE_MAX_AGE",
+	} {
+		env = append(env, key+"="+os.Getenv(key))
+	}
+
+	return env
+}
+
+// Run loads the index and answers requests until the context is canceled.
+func (d *Daemon) Run(ctx context.Context) error {
+	socketPath := GetDaemonSocketPath()
+	if socketPath == "" {
+		return errors.New("XDG_RUNTIME_DIR is not set")
+	}
+
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return fmt.Errorf("%w at %s", ErrDaemonRunning, socketPath)
+	}
+
+	d.opn = &Opn{
+		CacheFilePath: d.CacheFilePath,
+		SkipDaemon:    true,
+	}
+	err := d.opn.Load()
+	switch {
+	case errors.Is(err, FailedToSaveCache):
+		log.Printf("%v\n", err)
+	case err != nil:
+		return fmt.Errorf("failed to load: %w", err)
+	}
+
+	// Lookups must not modify the index as they are performed concurrently
+	d.opn.index.materialize()
+	d.env = daemonEnvironment(d.opn.GetCachePath())
+	d.maxCacheAge, err = d.opn.getMaxCacheAge()
+	if err != nil {
+		return err
+	}
+
+	d.watcher, err = newInotifyWatcher()
+	if err != nil {
+		return err
+	}
+	defer d.watcher.close()
+	d.watcher.watch(getSourceDirs(d.opn.index))
+
+	err = os.MkdirAll(path.Dir(socketPath), 0700)
+	if err != nil {
+		return fmt.Errorf("error creating socket directory: %w", err)
+	}
+
+	// The socket is stale, no daemon answered
+	_ = os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", socketPath, err)
+	}
+	defer listener.Close()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go d.watch(ctx)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("error accepting connection: %w", err)
+		}
+
+		go d.serve(conn)
+	}
+}
+
+// getSourceDirs returns the directories that hold the sources of the index.
+func getSourceDirs(index *Index) []string {
+	dirs := make([]string, 0, len(index.DesktopDirs))
+	for _, source := range index.DesktopDirs {
+		dirs = append(dirs, source.Path)
+	}
+
+	for _, source := range slices.Concat(index.MimeappsLists, index.MimeInfo.Sources) {
+		dirs = append(dirs, path.Dir(source.Path))
+	}
+
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
+
+// watch refreshes the index when changes are detected and periodically.
+func (d *Daemon) watch(ctx context.Context) {
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		for {
+			err := d.watcher.wait()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Stopped watching for changes: %v\n", err)
+				}
+				return
+			}
+
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(daemonRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				// Only the periodic refresh remains
+				changes = nil
+				continue
+			}
+
+			time.Sleep(daemonRefreshDelay)
+			select {
+			case <-changes:
+			default:
+			}
+		case <-ticker.C:
+		}
+
+		d.refresh()
+	}
+}
+
+// refresh updates the parts of the index that have changed and saves the cache if needed.
+func (d *Daemon) refresh() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	index := d.opn.index
+	if index.isExpired(d.maxCacheAge) {
+		newIndex, err := GenerateIndex()
+		if err != nil {
+			log.Printf("Failed to generate index: %v\n", err)
+			return
+		}
+
+		index = newIndex
+		d.opn.index = index
+	} else {
+		_, err := index.Refresh()
+		if err != nil {
+			log.Printf("Failed to refresh index: %v\n", err)
+		}
+
+		if index.refreshDesktopEntries() {
+			index.generateAssociations()
+			index.needsSave = true
+		}
+	}
+
+	d.watcher.watch(getSourceDirs(index))
+
+	if !index.needsSave {
+		return
+	}
+
+	err := d.save()
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+}
+
+// regenerate replaces the index with one generated from the file system and saves the cache.
+func (d *Daemon) regenerate() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	index, err := GenerateIndex()
+	if err != nil {
+		return fmt.Errorf("failed to generate index: %w", err)
+	}
+
+	d.opn.index = index
+	d.watcher.watch(getSourceDirs(index))
+
+	return d.save()
+}
+
+// save saves the index to the cache. d.mu must be locked.
+func (d *Daemon) save() error {
+	unlock, err := lockCache(d.opn.GetCachePath())
+	if err == nil {
+		defer unlock()
+	} else {
+		log.Printf("Failed to lock cache, continuing without lock: %v\n", err)
+	}
+
+	return d.opn.SaveIndex()
+}
+
+// serve answers the requests of a single connection.
+func (d *Daemon) serve(conn net.Conn) {
+	defer conn.Close()
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+
+	for {
+		_ = conn.SetDeadline(time.Now().Add(daemonIdleTimeout))
+
+		var request daemonRequest
+		if err := decoder.Decode(&request); err != nil {
+			return
+		}
+
+		if err := encoder.Encode(d.handle(request)); err != nil {
+			return
+		}
+	}
+}
+
+func (d *Daemon) handle(request daemonRequest) daemonResponse {
+	switch request.Method {
+	case daemonHello:
+		return newDaemonResponse(slices.Equal(request.Args, d.env), nil)
+	case daemonRegenerate:
+		return newDaemonResponse(true, d.regenerate())
+	case daemonStartDetached:
+		return newDaemonResponse(true, startDetachedByDaemon(request.Args))
+	}
+
+	method, ok := daemonMethods[request.Method]
+	if !ok || len(request.Args) != method.args {
+		return daemonResponse{
+			Error: fmt.Sprintf(
+				"invalid request: %s with %d arguments",
+				request.Method,
+				len(request.Args),
+			),
+		}
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return newDaemonResponse(method.call(d.opn, request.Args))
+}
+
+func newDaemonResponse(result any, err error) daemonResponse {
+	if err != nil {
+		return daemonResponse{
+			Error:  err.Error(),
+			Broken: errors.Is(err, ErrBrokenDesktopEntry),
+		}
+	}
+
+	resultJson, err := json.Marshal(result)
+	if err != nil {
+		return daemonResponse{Error: err.Error()}
+	}
+
+	return daemonResponse{Result: resultJson}
+}
@@ -0,0 +1,125 @@
+package opnlib
+
+import (
+	"context"
+	"github.com/MatthiasKunnen/xdg/basedir"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startTestDaemon runs a daemon until the test ends and returns the path of its cache.
+func startTestDaemon(t *testing.T) string {
+	t.Helper()
+	useMimeFixture(t, map[string]string{"types": "text/plain\n"})
+
+	oldRuntimeDir := basedir.RuntimeDir
+	basedir.RuntimeDir = t.TempDir()
+	t.Cleanup(func() {
+		basedir.RuntimeDir = oldRuntimeDir
+	})
+
+	cachePath := filepath.Join(t.TempDir(), "db.bin")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- (&Daemon{CacheFilePath: cachePath}).Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	for range 100 {
+		if _, err := os.Stat(GetDaemonSocketPath()); err == nil {
+			return cachePath
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("daemon did not start listening")
+	return ""
+}
+
+// waitForFile returns the content of the file once it has been written.
+func waitForFile(t *testing.T, path string) string {
+	t.Helper()
+	for range 100 {
+		content, err := os.ReadFile(path)
+		if err == nil && len(content) > 0 && content[len(content)-1] == '\n' {
+			return string(content)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("%s was not written", path)
+	return ""
+}
+
+func TestStartDetached(t *testing.T) {
+	cachePath := startTestDaemon(t)
+
+	tests := []struct {
+		name       string
+		skipDaemon bool
+	}{
+		{name: "daemon"},
+		{name: "local", skipDaemon: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opn := &Opn{CacheFilePath: cachePath, SkipDaemon: tt.skipDaemon}
+			if err := opn.Load(); err != nil {
+				t.Fatal(err)
+			}
+			defer opn.Close()
+
+			if usesDaemon := opn.daemon != nil; usesDaemon == tt.skipDaemon {
+				t.Fatalf("expected daemon use to be %t", !tt.skipDaemon)
+			}
+
+			dir := t.TempDir()
+			out := filepath.Join(t.TempDir(), "out")
+			err := opn.StartDetached(
+				dir,
+				[]string{"FOO=bar"},
+				[]string{"sh", "-c", `echo "$FOO $(pwd) $0" > "$1"`, "name", out},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := "bar " + dir + " name\n"
+			if got := waitForFile(t, out); got != expected {
+				t.Errorf("expected %q, got %q", expected, got)
+			}
+		})
+	}
+}
+
+func TestStartDetachedInvalidRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "no arguments"},
+		{name: "invalid environment size", args: []string{"/bin/sh", "/", "x", "sh"}},
+		{name: "environment too large", args: []string{"/bin/sh", "/", "2", "A=b", "sh"}},
+		{name: "relative program", args: []string{"sh", "/", "0", "sh"}},
+		{name: "relative directory", args: []string{"/bin/sh", "dir", "0", "sh"}},
+	}
+
+	d := &Daemon{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := d.handle(daemonRequest{Method: daemonStartDetached, Args: tt.args})
+			if response.Error == "" {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package opnlib
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	// daemonDialTimeout is kept short, if no daemon answers quickly, loading the cache is faster.
+	daemonDialTimeout = 100 * time.Millisecond
+
+	daemonCallTimeout = 10 * time.Second
+)
+
+var errDaemonIncompatible = errors.New("daemon uses a different environment")
+
+// daemonClient is a connection to a Daemon.
+type daemonClient struct {
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+// daemonError is an error returned by the daemon.
+type daemonError struct {
+	message string
+	broken  bool
+}
+
+func (e *daemonError) Error() string {
+	return e.message
+}
+
+func (e *daemonError) Is(target error) bool {
+	return e.broken && target == ErrBrokenDesktopEntry
+}
+
+// dialDaemon connects to the daemon and verifies that it can be used.
+func dialDaemon(socketPath string, cachePath string) (*daemonClient, error) {
+	conn, err := net.DialTimeout("unix", socketPath, daemonDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &daemonClient{
+		conn:    conn,
+		encoder: json.NewEncoder(conn),
+		decoder: json.NewDecoder(conn),
+	}
+
+	response, err := client.call(daemonHello, daemonEnvironment(cachePath))
+	if err != nil {
+		client.close()
+		return nil, err
+	}
+
+	var compatible bool
+	err = json.Unmarshal(response.Result, &compatible)
+	if err != nil || !compatible {
+		client.close()
+		return nil, errDaemonIncompatible
+	}
+
+	return client, nil
+}
+
+// call sends the request and waits for the response. An error is returned if the communication
+// with the daemon fails, errors of the lookup itself are part of the response.
+func (c *daemonClient) call(method string, args []string) (daemonResponse, error) {
+	var response daemonResponse
+	err := c.conn.SetDeadline(time.Now().Add(daemonCallTimeout))
+	if err != nil {
+		return response, err
+	}
+
+	err = c.encoder.Encode(daemonRequest{
+		Method: method,
+		Args:   args,
+	})
+	if err != nil {
+		return response, err
+	}
+
+	err = c.decoder.Decode(&response)
+	return response, err
+}
+
+func (c *daemonClient) close() {
+	_ = c.conn.Close()
+}
+
+// fromDaemon performs the lookup using the daemon. It returns false if no daemon is used, in which
+// case the lookup must be performed on the index. If the daemon can no longer be reached, the
+// index is loaded instead and false is returned.
+func fromDaemon[T any](opn *Opn, method string, args ...string) (T, bool, error) {
+	var result T
+	if opn.daemon == nil {
+		return result, false, nil
+	}
+
+	response, err := opn.daemon.call(method, args)
+	if err == nil && response.Error != "" {
+		return result, true, &daemonError{
+			message: response.Error,
+			broken:  response.Broken,
+		}
+	}
+
+	if err == nil {
+		err = json.Unmarshal(response.Result, &result)
+	}
+
+	if err != nil {
+		opn.stopUsingDaemon(err)
+		return result, false, nil
+	}
+
+	return result, true, nil
+}
+
+// stopUsingDaemon closes the connection to the daemon and loads the index.
+func (opn *Opn) stopUsingDaemon(reason error) {
+	log.Printf("Daemon stopped responding, loading the index instead: %v\n", reason)
+	opn.daemon.close()
+	opn.daemon = nil
+
+	err := opn.loadIndex()
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+
+	if opn.index == nil {
+		opn.index = &Index{}
+	}
+}
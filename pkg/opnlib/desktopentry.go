@@ -0,0 +1,308 @@
+package opnlib
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/xdg/desktop"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// ErrBrokenDesktopEntry is returned when none of the desktop files of a desktop ID could be parsed
+// when the index was generated and none of them have changed since.
+// The parse errors can be found using Opn.GetDesktopEntryErrors.
+var ErrBrokenDesktopEntry = errors.New("desktop entry could not be parsed")
+
+// LocalizedString is a localestring value of a desktop entry.
+type LocalizedString struct {
+	Default string
+
+	// Localized maps a locale, e.g. nl_BE, to the localized value.
+	Localized map[string]string `json:",omitempty"`
+}
+
+func newLocalizedString(defaultValue string, localized map[string]string) LocalizedString {
+	return LocalizedString{
+		Default:   defaultValue,
+		Localized: localized,
+	}
+}
+
+// DesktopAction is an additional application action of a desktop entry.
+type DesktopAction struct {
+	Id   string
+	Name LocalizedString
+	Exec desktop.ExecValue
+}
+
+// DesktopEntry holds the fields of a desktop entry that are used by opn.
+type DesktopEntry struct {
+	// FilePath is the path of the desktop file the entry was parsed from.
+	FilePath string
+
+	// Source holds the state of the desktop file when it was parsed.
+	Source SourceStat
+
+	Name        LocalizedString
+	GenericName LocalizedString
+	Comment     LocalizedString
+	Exec        desktop.ExecValue
+	TryExec     string
+	Terminal    bool
+	NoDisplay   bool
+	Hidden      bool
+	OnlyShowIn  []string
+	NotShowIn   []string
+	Actions     []DesktopAction
+	MimeType    []string
+
+	// DBusActivatable indicates that the application should be started using D-Bus rather than
+	// Exec.
+	DBusActivatable bool
+
+	// StartupNotify indicates that the application supports startup notification.
+	StartupNotify bool
+
+	// StartupWMClass is the WM class or app ID of the main window of the application.
+	StartupWMClass string
+
+	// Path is the working directory to run the program in.
+	Path string
+}
+
+// DesktopEntryError describes a desktop file that could not be parsed.
+type DesktopEntryError struct {
+	Path  string
+	Error string
+
+	// Source holds the state of the desktop file when parsing failed.
+	Source SourceStat
+}
+
+// parseDesktopEntry parses the desktop file and returns the fields used by opn.
+func parseDesktopEntry(filePath string) (DesktopEntry, error) {
+	// Stat before parsing so that changes made while parsing are detected
+	source := statSource(filePath)
+	entry, err := desktop.ParseFile(filePath)
+	if err != nil {
+		return DesktopEntry{}, err
+	}
+
+	actions := make([]DesktopAction, 0, len(entry.Actions))
+	for _, action := range entry.Actions {
+		actions = append(actions, DesktopAction{
+			Id:   action.Id,
+			Name: newLocalizedString(action.Name.Default, action.Name.Localized),
+			Exec: action.Exec,
+		})
+	}
+
+	return DesktopEntry{
+		FilePath:    filePath,
+		Source:      source,
+		Name:        newLocalizedString(entry.Name.Default, entry.Name.Localized),
+		GenericName: newLocalizedString(entry.GenericName.Default, entry.GenericName.Localized),
+		Comment:     newLocalizedString(entry.Comment.Default, entry.Comment.Localized),
+		Exec:        entry.Exec,
+		TryExec:     entry.TryExec,
+		Terminal:    entry.Terminal,
+		NoDisplay:   entry.NoDisplay,
+		Hidden:      entry.Hidden,
+		OnlyShowIn:  entry.OnlyShowIn,
+		NotShowIn:   entry.NotShowIn,
+		Actions:     actions,
+		MimeType:    entry.MimeType,
+
+		DBusActivatable: entry.DBusActivatable,
+		StartupNotify:   entry.StartupNotify,
+		StartupWMClass:  entry.StartupWMClass,
+		Path:            entry.Path,
+	}, nil
+}
+
+// generateDesktopEntries parses the desktop files of all desktop IDs. For every desktop ID, the
+// highest priority desktop file that can be parsed is used.
+func (index *Index) generateDesktopEntries() {
+	index.DesktopEntries = make(map[string]DesktopEntry, len(index.DesktopIdToPaths))
+	index.DesktopEntryErrors = make([]DesktopEntryError, 0)
+
+	for desktopId, paths := range index.DesktopIdToPaths {
+		index.parseDesktopEntry(desktopId, paths)
+	}
+
+	slices.SortFunc(index.DesktopEntryErrors, func(a, b DesktopEntryError) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+}
+
+// parseDesktopEntry stores the entry of the first desktop file that can be parsed. The errors of
+// the desktop files that are tried are recorded.
+func (index *Index) parseDesktopEntry(desktopId string, paths []string) {
+	for _, filePath := range paths {
+		entry, err := parseDesktopEntry(filePath)
+		if err == nil {
+			index.DesktopEntries[desktopId] = entry
+			return
+		}
+
+		index.DesktopEntryErrors = append(index.DesktopEntryErrors, DesktopEntryError{
+			Path:   filePath,
+			Error:  err.Error(),
+			Source: statSource(filePath),
+		})
+	}
+}
+
+// GetDesktopEntry returns the desktop entry of the desktop ID.
+// The entry is taken from the cache unless any of the desktop files of the desktop ID that it was
+// chosen from have changed since, in which case the desktop files are parsed again.
+// If no desktop file can be parsed, an error is returned. This error wraps ErrBrokenDesktopEntry
+// if the desktop files were already broken when the index was generated.
+func (opn *Opn) GetDesktopEntry(desktopId string) (DesktopEntry, error) {
+	if result, ok, err := fromDaemon[DesktopEntry](opn, "GetDesktopEntry", desktopId); ok {
+		return result, err
+	}
+
+	paths := opn.GetDesktopFileLocations(desktopId)
+	if len(paths) == 0 {
+		return DesktopEntry{}, fmt.Errorf("no desktop file found for %s", desktopId)
+	}
+
+	entry, ok := opn.index.lookupDesktopEntry(desktopId)
+	if ok && opn.index.isEntryUnchanged(entry, paths) {
+		return entry, nil
+	}
+
+	if !ok && opn.index.isKnownBroken(paths) {
+		return DesktopEntry{}, fmt.Errorf("%w: %s", ErrBrokenDesktopEntry, desktopId)
+	}
+
+	var errs []error
+	for _, filePath := range paths {
+		entry, err := parseDesktopEntry(filePath)
+		if err == nil {
+			return entry, nil
+		}
+
+		errs = append(errs, fmt.Errorf("error parsing desktop file %s: %w", filePath, err))
+	}
+
+	return DesktopEntry{}, errors.Join(errs...)
+}
+
+// GetDesktopEntryErrors returns the desktop files that could not be parsed when the index was
+// generated, sorted by path.
+func (opn *Opn) GetDesktopEntryErrors() []DesktopEntryError {
+	if result, ok, _ := fromDaemon[[]DesktopEntryError](opn, "GetDesktopEntryErrors"); ok {
+		return result
+	}
+
+	return opn.index.DesktopEntryErrors
+}
+
+// isKnownBroken returns true if all paths failed to parse when the index was generated and none
+// of them have changed since.
+func (index *Index) isKnownBroken(paths []string) bool {
+	for _, filePath := range paths {
+		i := slices.IndexFunc(index.DesktopEntryErrors, func(e DesktopEntryError) bool {
+			return e.Path == filePath
+		})
+		if i == -1 || !index.DesktopEntryErrors[i].Source.isUnchanged() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isEntryUnchanged returns true if the desktop file of the entry is unchanged and the desktop files
+// of higher priority, given in paths, are still broken. Otherwise, a different desktop file might
+// be chosen when parsing the desktop ID again.
+func (index *Index) isEntryUnchanged(entry DesktopEntry, paths []string) bool {
+	if !entry.Source.isUnchanged() {
+		return false
+	}
+
+	for _, filePath := range paths {
+		if filePath == entry.FilePath {
+			return true
+		}
+
+		if !index.isKnownBroken([]string{filePath}) {
+			return false
+		}
+	}
+
+	// The desktop file is no longer one of the desktop ID
+	return false
+}
+
+// refreshDesktopEntries parses the desktop files of the desktop IDs for which any desktop file has
+// been modified since they were parsed. Returns true if any desktop ID has been parsed again.
+func (index *Index) refreshDesktopEntries() bool {
+	index.materialize()
+	changed := make(map[string]bool)
+	for desktopId, paths := range index.DesktopIdToPaths {
+		entry, ok := index.DesktopEntries[desktopId]
+		if (ok && !index.isEntryUnchanged(entry, paths)) || (!ok && !index.isKnownBroken(paths)) {
+			changed[desktopId] = true
+		}
+	}
+
+	for desktopId := range changed {
+		paths := index.DesktopIdToPaths[desktopId]
+		delete(index.DesktopEntries, desktopId)
+		index.DesktopEntryErrors = slices.DeleteFunc(
+			index.DesktopEntryErrors,
+			func(e DesktopEntryError) bool {
+				return slices.Contains(paths, e.Path)
+			},
+		)
+
+		index.parseDesktopEntry(desktopId, paths)
+	}
+
+	slices.SortFunc(index.DesktopEntryErrors, func(a, b DesktopEntryError) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return len(changed) > 0
+}
+
+// HiddenReason applies the visibility rules of the desktop entry spec and returns why the entry
+// must not be shown, or an empty string if it should be shown. The rules are NoDisplay, Hidden,
+// TryExec, and OnlyShowIn and NotShowIn which are compared against currentDesktop, the
+// colon-separated value of XDG_CURRENT_DESKTOP.
+func (entry DesktopEntry) HiddenReason(currentDesktop string) string {
+	switch {
+	case entry.Hidden:
+		return "Hidden is set"
+	case entry.NoDisplay:
+		return "NoDisplay is set"
+	}
+
+	if entry.TryExec != "" {
+		if _, err := exec.LookPath(entry.TryExec); err != nil {
+			return fmt.Sprintf("TryExec %s not found", entry.TryExec)
+		}
+	}
+
+	// The first desktop of XDG_CURRENT_DESKTOP that is listed in either key decides
+	for _, d := range strings.Split(currentDesktop, ":") {
+		switch {
+		case d == "":
+			continue
+		case slices.Contains(entry.OnlyShowIn, d):
+			return ""
+		case slices.Contains(entry.NotShowIn, d):
+			return "NotShowIn contains " + d
+		}
+	}
+
+	if len(entry.OnlyShowIn) > 0 {
+		return "OnlyShowIn is " + strings.Join(entry.OnlyShowIn, ";")
+	}
+
+	return ""
+}
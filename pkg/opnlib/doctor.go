@@ -0,0 +1,291 @@
+package opnlib
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/xdg/desktop"
+	"io/fs"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// DoctorSeverity indicates how serious a DoctorProblem is.
+type DoctorSeverity string
+
+const (
+	// DoctorError is used for problems that make an application unusable.
+	DoctorError DoctorSeverity = "error"
+
+	// DoctorWarning is used for problems that likely cause unexpected behavior.
+	DoctorWarning DoctorSeverity = "warning"
+
+	// DoctorInfo is used for findings that are not necessarily a problem.
+	DoctorInfo DoctorSeverity = "info"
+)
+
+// The checks performed by Doctor.
+const (
+	CheckUnparsableDesktopFile  = "unparsable-desktop-file"
+	CheckUnparsableMimeappsList = "unparsable-mimeapps-list"
+	CheckExecNotFound           = "exec-not-found"
+	CheckTryExecFailed          = "tryexec-failed"
+	CheckUnknownDesktopId       = "unknown-desktop-id"
+	CheckShadowedDesktopId      = "shadowed-desktop-id"
+	CheckUnknownMimeType        = "unknown-mime-type"
+	CheckMissingFieldCode       = "missing-field-code"
+	CheckMimeDatabaseMissing    = "mime-database-missing"
+)
+
+// DoctorProblem is a problem found by Doctor.
+type DoctorProblem struct {
+	Severity DoctorSeverity
+
+	// Check is the check that found the problem, e.g. CheckExecNotFound.
+	Check string
+
+	// Path is the desktop or mimeapps.list file the problem was found in.
+	Path      string `json:",omitempty"`
+	DesktopId string `json:",omitempty"`
+	Mime      string `json:",omitempty"`
+	Message   string
+}
+
+var doctorSeverityOrder = []DoctorSeverity{DoctorError, DoctorWarning, DoctorInfo}
+
+// Doctor generates the index from the file system and checks the desktop files and mimeapps.list
+// files it is generated from for problems.
+// The problems are sorted by severity, most severe first, and path.
+func Doctor() ([]DoctorProblem, error) {
+	index, err := GenerateIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate index: %w", err)
+	}
+
+	knownMimes, found, err := loadMimeTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MIME types: %w", err)
+	}
+
+	d := doctor{
+		index:      index,
+		knownMimes: knownMimes,
+		problems:   make([]DoctorProblem, 0),
+	}
+
+	if !found {
+		d.knownMimes = nil
+		d.report(DoctorProblem{
+			Severity: DoctorWarning,
+			Check:    CheckMimeDatabaseMissing,
+			Message:  "the shared-mime-info database was not found, MIME types are not checked",
+		})
+	}
+
+	d.checkDesktopEntries()
+	d.checkMimeappsLists()
+
+	slices.SortStableFunc(d.problems, func(a, b DoctorProblem) int {
+		return cmp.Or(
+			cmp.Compare(
+				slices.Index(doctorSeverityOrder, a.Severity),
+				slices.Index(doctorSeverityOrder, b.Severity),
+			),
+			strings.Compare(a.Path, b.Path),
+			strings.Compare(a.Check, b.Check),
+			strings.Compare(a.Message, b.Message),
+		)
+	})
+
+	return d.problems, nil
+}
+
+type doctor struct {
+	index *Index
+
+	// knownMimes maps the lowercase MIME types of the shared-mime-info database to their spelling.
+	// It is nil if the database could not be found.
+	knownMimes map[string]string
+	problems   []DoctorProblem
+}
+
+func (d *doctor) report(problem DoctorProblem) {
+	d.problems = append(d.problems, problem)
+}
+
+// checkMime reports the MIME type if it is not part of the shared-mime-info database.
+func (d *doctor) checkMime(filePath string, desktopId string, mime string) {
+	lowerMime := strings.ToLower(mime)
+	if d.knownMimes == nil ||
+		d.knownMimes[lowerMime] != "" ||
+		d.index.MimeInfo.Aliases[lowerMime] != "" ||
+		strings.HasPrefix(lowerMime, "x-scheme-handler/") {
+		return
+	}
+
+	d.report(DoctorProblem{
+		Severity:  DoctorWarning,
+		Check:     CheckUnknownMimeType,
+		Path:      filePath,
+		DesktopId: desktopId,
+		Mime:      mime,
+		Message:   fmt.Sprintf("MIME type %s is not in the shared-mime-info database", mime),
+	})
+}
+
+func (d *doctor) checkDesktopEntries() {
+	for _, entryError := range d.index.DesktopEntryErrors {
+		d.report(DoctorProblem{
+			Severity: DoctorError,
+			Check:    CheckUnparsableDesktopFile,
+			Path:     entryError.Path,
+			Message:  entryError.Error,
+		})
+	}
+
+	associated := make(map[string]bool)
+	for _, desktopIds := range d.index.Associations {
+		for _, desktopId := range desktopIds {
+			associated[desktopId] = true
+		}
+	}
+
+	for desktopId, paths := range d.index.DesktopIdToPaths {
+		for _, shadowed := range paths[1:] {
+			d.report(DoctorProblem{
+				Severity:  DoctorInfo,
+				Check:     CheckShadowedDesktopId,
+				Path:      shadowed,
+				DesktopId: desktopId,
+				Message:   fmt.Sprintf("shadowed by %s", paths[0]),
+			})
+		}
+
+		entry, ok := d.index.DesktopEntries[desktopId]
+		if !ok {
+			continue
+		}
+
+		problem := DoctorProblem{
+			Path:      entry.FilePath,
+			DesktopId: desktopId,
+		}
+
+		if entry.TryExec != "" {
+			if _, err := exec.LookPath(entry.TryExec); err != nil {
+				problem.Severity = DoctorWarning
+				problem.Check = CheckTryExecFailed
+				problem.Message = fmt.Sprintf(
+					"TryExec %s is not installed, the application is ignored",
+					entry.TryExec,
+				)
+				d.report(problem)
+			}
+		}
+
+		execValues := []desktop.ExecValue{entry.Exec}
+		for _, action := range entry.Actions {
+			execValues = append(execValues, action.Exec)
+		}
+		for _, execValue := range execValues {
+			program := getExecProgram(execValue)
+			if program == "" {
+				continue
+			}
+
+			if _, err := exec.LookPath(program); err != nil {
+				problem.Severity = DoctorError
+				problem.Check = CheckExecNotFound
+				problem.Message = fmt.Sprintf("program %s of Exec %s not found", program, execValue)
+				d.report(problem)
+			}
+		}
+
+		if associated[desktopId] && entry.Exec != "" && !entry.Exec.CanOpenFiles() {
+			problem.Severity = DoctorWarning
+			problem.Check = CheckMissingFieldCode
+			problem.Message = "the Exec value has no field code for files or URLs, " +
+				"the path is added as last argument"
+			d.report(problem)
+		}
+
+		for _, mime := range entry.MimeType {
+			d.checkMime(entry.FilePath, desktopId, mime)
+		}
+	}
+}
+
+func (d *doctor) checkMimeappsLists() {
+	for _, listPath := range getCurrentMimeappsListPaths() {
+		list, issues, err := parseMimeappsList(listPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		case err != nil:
+			d.report(DoctorProblem{
+				Severity: DoctorError,
+				Check:    CheckUnparsableMimeappsList,
+				Path:     listPath,
+				Message:  err.Error(),
+			})
+			continue
+		}
+
+		for _, issue := range issues {
+			d.report(DoctorProblem{
+				Severity: DoctorWarning,
+				Check:    CheckUnparsableMimeappsList,
+				Path:     listPath,
+				Message:  fmt.Sprintf("line %d is ignored, %s", issue.line, issue.message),
+			})
+		}
+
+		for section, associations := range list {
+			for mime, desktopIds := range associations {
+				d.checkMime(listPath, "", mime)
+
+				if section == SectionRemovedAssociations {
+					continue
+				}
+
+				for _, desktopId := range desktopIds {
+					if _, ok := d.index.DesktopIdToPaths[desktopId]; ok {
+						continue
+					}
+
+					d.report(DoctorProblem{
+						Severity:  DoctorWarning,
+						Check:     CheckUnknownDesktopId,
+						Path:      listPath,
+						DesktopId: desktopId,
+						Mime:      mime,
+						Message: fmt.Sprintf(
+							"[%s] associates %s with %s which does not exist",
+							section,
+							mime,
+							desktopId,
+						),
+					})
+				}
+			}
+		}
+	}
+}
+
+// getExecProgram returns the program that is executed by the Exec value.
+func getExecProgram(execValue desktop.ExecValue) string {
+	arguments := execValue.ToArguments(desktop.FieldCodeProvider{
+		GetDesktopFileLocation: func() string { return "" },
+		GetFile:                func() string { return "" },
+		GetFiles:               func() []string { return nil },
+		GetName:                func() string { return "" },
+		GetUrl:                 func() string { return "" },
+		GetUrls:                func() []string { return nil },
+	})
+	if len(arguments) == 0 {
+		return ""
+	}
+
+	return arguments[0]
+}
@@ -0,0 +1,102 @@
+package opnlib
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// SourceStat holds the state of a file or directory the index was generated from. It is used to
+// cheaply determine whether the index is out-of-date.
+type SourceStat struct {
+	Path    string
+	Exists  bool
+	ModTime time.Time
+	Size    int64
+	Inode   uint64
+}
+
+// statSource returns the current state of the file or directory at the given path.
+func statSource(path string) SourceStat {
+	info, err := os.Stat(path)
+	if err != nil {
+		return SourceStat{Path: path}
+	}
+
+	result := SourceStat{
+		Path:    path,
+		Exists:  true,
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+	}
+
+	if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+		result.Inode = sys.Ino
+	}
+
+	return result
+}
+
+// statSources returns the current state of all paths.
+func statSources(paths []string) []SourceStat {
+	result := make([]SourceStat, 0, len(paths))
+	for _, p := range paths {
+		result = append(result, statSource(p))
+	}
+
+	return result
+}
+
+// statDirsRecursive returns the state of the given directories and all their subdirectories.
+// Directories that do not exist are included so that their creation can be detected.
+func statDirsRecursive(dirs []string) []SourceStat {
+	result := make([]SourceStat, 0, len(dirs))
+	for _, dir := range dirs {
+		root := statSource(dir)
+		result = append(result, root)
+		if !root.Exists {
+			continue
+		}
+
+		_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() || path == dir {
+				return nil
+			}
+
+			result = append(result, statSource(path))
+			return nil
+		})
+	}
+
+	return result
+}
+
+// isUnchanged returns true if the source still has the recorded state.
+func (s SourceStat) isUnchanged() bool {
+	current := statSource(s.Path)
+	return current.Exists == s.Exists &&
+		current.ModTime.Equal(s.ModTime) &&
+		current.Size == s.Size &&
+		current.Inode == s.Inode
+}
+
+// sourcesFresh returns true if all recorded sources are unchanged and all expected paths are
+// recorded. The latter detects changes in the environment, e.g. XDG_DATA_DIRS.
+func sourcesFresh(recorded []SourceStat, expectedPaths []string) bool {
+	for _, p := range expectedPaths {
+		if !slices.ContainsFunc(recorded, func(s SourceStat) bool { return s.Path == p }) {
+			return false
+		}
+	}
+
+	for _, source := range recorded {
+		if !source.isUnchanged() {
+			return false
+		}
+	}
+
+	return true
+}
@@ -2,14 +2,55 @@ package opnlib
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/MatthiasKunnen/xdg/desktop"
 	"github.com/MatthiasKunnen/xdg/mimeapps"
+	"io"
+	"maps"
 	"os"
 	"path"
+	"syscall"
 	"time"
 )
 
+// IndexVersion is the version of the index format. It must be incremented whenever the structure
+// of Index, or the meaning of its fields, changes. Indexes with a different version are not loaded.
+//
+// History:
+//   - 1: Version, GeneratedOn, Associations, and DesktopIdToPaths.
+//   - 2: Added DesktopDirs and MimeappsLists.
+//   - 3: The MIME types in Associations are lowercase. Added the binary format.
+//   - 4: Added MimeInfo.
+//   - 5: Added DesktopEntries and DesktopEntryErrors.
+//   - 6: Added DesktopEntry.DBusActivatable.
+//   - 7: Added DesktopEntry.StartupNotify and StartupWMClass.
+//   - 8: Added DesktopEntry.Path.
+//   - 9: Added MimeInfo.Types. The values of MimeInfo are spelled as in the database.
+//   - 10: Aliases in Associations are resolved to their canonical MIME type.
+const IndexVersion = 10
+
+// ErrIndexVersionMismatch is returned when loading an index with a version other than
+// IndexVersion.
+var ErrIndexVersionMismatch = errors.New("index version mismatch")
+
+// Index holds the lookup maps for associations and desktop IDs.
+//
+// The index is stored either in a binary format, see binaryIndexMagic, or as JSON. Files with the
+// .json extension use JSON.
+// In the JSON format, the index is an object with the exported fields of this struct as keys:
+//   - Version: number, see IndexVersion.
+//   - GeneratedOn: RFC 3339 timestamp of the time the desktop IDs were last gathered.
+//   - Associations: object of MIME type to array of desktop IDs, in order of preference.
+//   - DesktopIdToPaths: object of desktop ID to array of desktop file paths, in order of priority.
+//   - DesktopDirs and MimeappsLists: arrays of objects with the keys Path (string), Exists (bool),
+//     ModTime (RFC 3339 timestamp), Size (number), and Inode (number).
+//   - MimeInfo: object with the keys Subclasses (object of MIME type to array of parent MIME
+//     types), Aliases (object of alias to MIME type), Types (object of lowercase MIME type to MIME
+//     type), Globs (array of objects with the keys Weight, Mime, Pattern, and CaseSensitive), and
+//     Sources (like DesktopDirs).
+//   - DesktopEntries: object of desktop ID to the JSON encoding of DesktopEntry.
+//   - DesktopEntryErrors: array of the JSON encoding of DesktopEntryError.
 type Index struct {
 	Version     int
 	GeneratedOn time.Time
@@ -21,18 +62,76 @@ type Index struct {
 	// DesktopIdToPaths maps a desktop ID to the desktop files in the file system.
 	DesktopIdToPaths desktop.IdPathMap
 
-	// isNewlyGenerated is true when the index is not loaded from cache.
-	isNewlyGenerated bool
+	// DesktopDirs holds the state of the application directories, and their subdirectories, at
+	// the time DesktopIdToPaths was generated.
+	DesktopDirs []SourceStat
+
+	// MimeappsLists holds the state of the mimeapps.list files at the time Associations was
+	// generated. These are the files Associations is generated from, see
+	// getCurrentMimeappsListPaths.
+	MimeappsLists []SourceStat
+
+	// MimeInfo holds the subclasses, aliases, and globs of the shared-mime-info database.
+	MimeInfo MimeInfo
+
+	// DesktopEntries maps a desktop ID to the parsed desktop entry of its highest priority desktop
+	// file that could be parsed.
+	DesktopEntries map[string]DesktopEntry
+
+	// DesktopEntryErrors contains the desktop files that could not be parsed.
+	DesktopEntryErrors []DesktopEntryError
+
+	// needsSave is true when the index is not loaded from cache or when it has been
+	// (partially) regenerated after loading, and it has not been saved since.
+	needsSave bool
+
+	// lazy is set when the index is loaded from a binary file. Until materialize is called,
+	// Associations, DesktopIdToPaths, and DesktopEntries are nil and lookups are performed on the
+	// binary tables.
+	lazy *lazyIndex
 }
 
-// LoadIndex loads the index.
+// LoadIndex loads the index. Files with the .json extension are loaded as JSON, others using the
+// binary format.
+// If the version of the index does not equal IndexVersion, an error wrapping
+// ErrIndexVersionMismatch is returned.
 func LoadIndex(filename string) (*Index, error) {
+	if isJsonIndexPath(filename) {
+		return loadJsonIndex(filename)
+	}
+
+	return loadBinaryIndex(filename)
+}
+
+func isJsonIndexPath(filename string) bool {
+	return path.Ext(filename) == ".json"
+}
+
+func loadJsonIndex(filename string) (*Index, error) {
 	content, err := os.ReadFile(filename)
 
 	if err != nil {
 		return nil, fmt.Errorf("error loading index from '%s': %w", filename, err)
 	}
 
+	var versionOnly struct {
+		Version int
+	}
+	err = json.Unmarshal(content, &versionOnly)
+	if err != nil {
+		return nil, fmt.Errorf("parsing error loading index from '%s': %w", filename, err)
+	}
+
+	if versionOnly.Version != IndexVersion {
+		return nil, fmt.Errorf(
+			"%w: index at '%s' has version %d, expected %d",
+			ErrIndexVersionMismatch,
+			filename,
+			versionOnly.Version,
+			IndexVersion,
+		)
+	}
+
 	var db Index
 	err = json.Unmarshal(content, &db)
 	if err != nil {
@@ -42,46 +141,290 @@ func LoadIndex(filename string) (*Index, error) {
 	return &db, nil
 }
 
-// SaveIndex saves the index into the given file.
+// SaveIndex saves the index into the given file. Files with the .json extension are saved as JSON,
+// others using the binary format.
+// The index is written to a temporary file which then replaces the given file. This way, readers
+// never see a partially written index.
 func (index *Index) SaveIndex(filename string) error {
-	err := os.MkdirAll(path.Dir(filename), 0750)
+	dir := path.Dir(filename)
+	err := os.MkdirAll(dir, 0750)
 	if err != nil {
 		return err
 	}
 
-	var file *os.File
-	file, err = os.Create(filename)
+	file, err := os.CreateTemp(dir, path.Base(filename)+".*.tmp")
 	if err != nil {
-		return fmt.Errorf("error creating file at %s: %w", filename, err)
+		return fmt.Errorf("error creating temporary file in %s: %w", dir, err)
 	}
-	defer file.Close()
+	tempName := file.Name()
+	defer os.Remove(tempName) // No-op after a successful rename
 
-	err = json.NewEncoder(file).Encode(index)
+	if isJsonIndexPath(filename) {
+		err = index.WriteJson(file)
+	} else {
+		err = writeBinaryIndex(file, index)
+	}
 	if err != nil {
-		return fmt.Errorf("error saving JSON data at %s: %w", filename, err)
+		file.Close()
+		return fmt.Errorf("error saving index at %s: %w", tempName, err)
 	}
 
-	return nil
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error syncing %s: %w", tempName, err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("error closing %s: %w", tempName, err)
+	}
+
+	err = os.Rename(tempName, filename)
+	if err != nil {
+		return fmt.Errorf("error moving %s to %s: %w", tempName, filename, err)
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir makes sure the directory entries, e.g. after a rename, are persisted.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// lockCache acquires an exclusive advisory lock for the cache file. It blocks until the lock is
+// acquired. The returned function releases the lock.
+func lockCache(filename string) (func(), error) {
+	lockPath := filename + ".lock"
+	err := os.MkdirAll(path.Dir(lockPath), 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file %s: %w", lockPath, err)
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error locking %s: %w", lockPath, err)
+	}
+
+	return func() {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// WriteJson writes the index as JSON.
+func (index *Index) WriteJson(w io.Writer) error {
+	index.materialize()
+	return json.NewEncoder(w).Encode(index)
+}
+
+// materialize decodes the associations and desktop IDs of a lazily loaded index.
+func (index *Index) materialize() {
+	if index.lazy == nil {
+		return
+	}
+
+	index.Associations = index.lazy.associations.all()
+	index.DesktopIdToPaths = index.lazy.desktopIds.all()
+	index.DesktopEntries = index.lazy.allDesktopEntries()
+	index.release()
+}
+
+// release unmaps the file of a lazily loaded index. The index must not be used for lookups
+// afterward unless it has been materialized.
+func (index *Index) release() {
+	if index.lazy == nil {
+		return
+	}
+
+	_ = syscall.Munmap(index.lazy.data)
+	index.lazy = nil
+}
+
+// lookupAssociations returns the desktop IDs associated with the MIME type. The result must not
+// be modified.
+func (index *Index) lookupAssociations(mimeType string) ([]string, bool) {
+	if index.lazy != nil {
+		return index.lazy.associations.lookup(mimeType)
+	}
+
+	desktopIds, ok := index.Associations[mimeType]
+	return desktopIds, ok
+}
+
+// lookupDesktopFilePaths returns the paths of the desktop files of the desktop ID. The result must
+// not be modified.
+func (index *Index) lookupDesktopFilePaths(desktopId string) []string {
+	if index.lazy != nil {
+		paths, _ := index.lazy.desktopIds.lookup(desktopId)
+		return paths
+	}
+
+	return index.DesktopIdToPaths[desktopId]
+}
+
+// lookupDesktopEntry returns the cached desktop entry of the desktop ID.
+func (index *Index) lookupDesktopEntry(desktopId string) (DesktopEntry, bool) {
+	if index.lazy != nil {
+		return index.lazy.lookupDesktopEntry(desktopId)
+	}
+
+	entry, ok := index.DesktopEntries[desktopId]
+	return entry, ok
+}
+
+// MimeTypeCount returns the amount of MIME types in the index.
+func (index *Index) MimeTypeCount() int {
+	if index.lazy != nil {
+		return index.lazy.associations.count
+	}
+
+	return len(index.Associations)
+}
+
+// DesktopIdCount returns the amount of desktop IDs in the index.
+func (index *Index) DesktopIdCount() int {
+	if index.lazy != nil {
+		return index.lazy.desktopIds.count
+	}
+
+	return len(index.DesktopIdToPaths)
 }
 
 // GenerateIndex generates the index used to look up MIME type/Application associations and the
 // paths of desktop files.
 func GenerateIndex() (*Index, error) {
+	index := &Index{
+		Version:     IndexVersion,
+		GeneratedOn: time.Now(),
+		needsSave:   true,
+	}
+
+	err := index.generateDesktopIds()
+	if err != nil {
+		return nil, err
+	}
+
+	// The associations are merged using the aliases
+	index.MimeInfo, err = loadMimeInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	index.generateAssociations()
+
+	return index, nil
+}
+
+// isExpired returns true if the index is older than the max age. A negative max age never expires.
+func (index *Index) isExpired(maxAge time.Duration) bool {
+	return maxAge >= 0 && !index.GeneratedOn.Add(maxAge).After(time.Now())
+}
+
+// isUpToDate returns true if the index is not expired and none of its sources have changed.
+func (index *Index) isUpToDate(maxAge time.Duration) bool {
+	desktopDirsFresh, listsFresh, mimeInfoFresh := index.sourcesFresh()
+	return !index.isExpired(maxAge) && desktopDirsFresh && listsFresh && mimeInfoFresh
+}
+
+// sourcesFresh returns whether the application directories, the mimeapps.list files, and the
+// shared-mime-info database are unchanged since the index was generated.
+func (index *Index) sourcesFresh() (bool, bool, bool) {
+	return sourcesFresh(index.DesktopDirs, desktop.GetDesktopFileLocations()),
+		sourcesFresh(index.MimeappsLists, getCurrentMimeappsListPaths()),
+		sourcesFresh(index.MimeInfo.Sources, getMimeInfoPaths())
+}
+
+// Refresh regenerates the parts of the index whose source files or directories have changed since
+// they were generated. Returns true if the index has been changed.
+// Only the modification of directories, mimeapps.list files, and the shared-mime-info database
+// files is detected. Desktop files that are modified in place are not.
+func (index *Index) Refresh() (bool, error) {
+	desktopDirsFresh, listsFresh, mimeInfoFresh := index.sourcesFresh()
+
+	if desktopDirsFresh && listsFresh && mimeInfoFresh {
+		return false, nil
+	}
+
+	index.needsSave = true
+
+	aliasesChanged := false
+	if !mimeInfoFresh {
+		mimeInfo, err := loadMimeInfo()
+		if err != nil {
+			return false, err
+		}
+
+		aliasesChanged = !maps.Equal(index.MimeInfo.Aliases, mimeInfo.Aliases)
+		index.MimeInfo = mimeInfo
+	}
+
+	if desktopDirsFresh && listsFresh && !aliasesChanged {
+		return true, nil
+	}
+
+	if !desktopDirsFresh {
+		err := index.generateDesktopIds()
+		if err != nil {
+			return false, err
+		}
+
+		index.GeneratedOn = time.Now()
+	}
+
+	// The associations depend on the contents of the desktop files
+	index.materialize()
+	index.generateAssociations()
+
+	return true, nil
+}
+
+func (index *Index) generateDesktopIds() error {
 	locations := desktop.GetDesktopFileLocations()
+	// Stat before reading so that changes made during the generation are detected next time
+	dirs := statDirsRecursive(locations)
 	idPathMap, err := desktop.GetDesktopFiles(locations)
 	if err != nil {
-		return nil, fmt.Errorf("error getting desktop files: %w", err)
+		return fmt.Errorf("error getting desktop files: %w", err)
 	}
 
-	currentDesktop := os.Getenv("XDG_CURRENT_DESKTOP")
-	lists := mimeapps.GetLists(currentDesktop)
-	associations := mimeapps.GetPreferredApplications(lists, idPathMap)
+	index.DesktopIdToPaths = idPathMap
+	index.DesktopDirs = dirs
+	index.release()
+	index.generateDesktopEntries()
 
-	return &Index{
-		Version:          1,
-		GeneratedOn:      time.Now(),
-		Associations:     associations,
-		DesktopIdToPaths: idPathMap,
-		isNewlyGenerated: true,
-	}, nil
+	return nil
+}
+
+func (index *Index) generateAssociations() {
+	paths := getCurrentMimeappsListPaths()
+	// Stat before reading so that changes made during the generation are detected next time
+	index.MimeappsLists = statSources(paths)
+	index.Associations = index.mergeAssociations(paths).associations
+}
+
+// mergeAssociations merges the mimeapps.list files at the given paths with the desktop entries of
+// the index. The index must be materialized and its MimeInfo loaded.
+func (index *Index) mergeAssociations(paths []string) mergedAssociations {
+	lists := loadMimeappsLists(paths)
+	return mergeAssociations(
+		lists,
+		index.DesktopIdToPaths,
+		index.DesktopEntries,
+		desktop.GetDesktopFileLocations(),
+		index.MimeInfo.Aliases,
+	)
 }
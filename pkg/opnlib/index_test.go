@@ -0,0 +1,102 @@
+package opnlib
+
+import (
+	"github.com/MatthiasKunnen/xdg/basedir"
+	"github.com/MatthiasKunnen/xdg/desktop"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSaveIndexConcurrent loads and saves the same cache from many goroutines, while others read
+// it without taking the lock. The shared-mime-info database is modified before every load so that
+// the writers find the cache stale and refresh it while holding the lock. Every load must succeed
+// and see a complete index.
+func TestSaveIndexConcurrent(t *testing.T) {
+	const (
+		mimeCount      = 200
+		desktopIdCount = 100
+		workers        = 8
+		iterations     = 25
+	)
+
+	for _, filename := range []string{"db.bin", "db.json"} {
+		t.Run(filepath.Ext(filename)[1:], func(t *testing.T) {
+			useMimeFixture(t, map[string]string{"types": "text/plain\n"})
+			typesPath := filepath.Join(basedir.DataHome, "mime", "types")
+			var modifications atomic.Int64
+
+			cachePath := filepath.Join(t.TempDir(), filename)
+			index := newTestIndex(mimeCount, desktopIdCount)
+
+			// Record the current sources so that only the shared-mime-info database is refreshed
+			// and the associations of the test index are kept
+			index.DesktopDirs = statDirsRecursive(desktop.GetDesktopFileLocations())
+			index.MimeappsLists = statSources(getCurrentMimeappsListPaths())
+			index.MimeInfo.Sources = statSources(getMimeInfoPaths())
+			if err := index.SaveIndex(cachePath); err != nil {
+				t.Fatal(err)
+			}
+
+			checkIndex := func(opn *Opn) {
+				if count := opn.index.MimeTypeCount(); count != mimeCount {
+					t.Errorf("expected %d MIME types, got %d", mimeCount, count)
+				}
+
+				desktopIds := opn.GetDesktopIdsForMime("application/x-type100")
+				if len(desktopIds) != 3 {
+					t.Errorf("expected 3 desktop IDs, got %v", desktopIds)
+				}
+			}
+
+			var wg sync.WaitGroup
+			for range workers {
+				// Writer, makes the cache stale and loads it, which refreshes and saves it with the
+				// lock
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range iterations {
+						modTime := time.Now().Add(time.Duration(modifications.Add(1)) * time.Second)
+						if err := os.Chtimes(typesPath, modTime, modTime); err != nil {
+							t.Errorf("failed to modify the database: %v", err)
+							return
+						}
+
+						opn := &Opn{
+							CacheFilePath: cachePath,
+							SkipDaemon:    true,
+							MaxCacheAge:   time.Hour,
+						}
+						if err := opn.Load(); err != nil {
+							t.Errorf("failed to load: %v", err)
+							return
+						}
+						checkIndex(opn)
+						opn.Close()
+					}
+				}()
+
+				// Reader, loads the file directly so that a corrupt cache is not regenerated
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range iterations {
+						loaded, err := LoadIndex(cachePath)
+						if err != nil {
+							t.Errorf("failed to load: %v", err)
+							return
+						}
+
+						checkIndex(&Opn{index: loaded})
+						loaded.release()
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
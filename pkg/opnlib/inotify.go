@@ -0,0 +1,61 @@
+package opnlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF |
+	syscall.IN_MOVE_SELF
+
+// inotifyWatcher watches directories for changes. It does not report what changed, the index
+// determines this itself using the state of its sources.
+type inotifyWatcher struct {
+	fd   int
+	file *os.File
+}
+
+func newInotifyWatcher() (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing inotify: %w", err)
+	}
+
+	// The descriptor is non-blocking which makes the file pollable, this allows Close to interrupt
+	// a pending Read. Calling Fd on the file would make it blocking again, hence fd is kept.
+	return &inotifyWatcher{
+		fd:   fd,
+		file: os.NewFile(uintptr(fd), "inotify"),
+	}, nil
+}
+
+// watch adds the directories to the watch list. For directories that do not exist, the closest
+// existing parent directory is watched instead so that their creation is noticed.
+// Directories that are already watched are not affected.
+func (w *inotifyWatcher) watch(dirs []string) {
+	for _, dir := range dirs {
+		for {
+			_, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+			parent := filepath.Dir(dir)
+			if err == nil || parent == dir {
+				break
+			}
+
+			dir = parent
+		}
+	}
+}
+
+// wait blocks until at least one event has occurred and discards the pending events.
+func (w *inotifyWatcher) wait() error {
+	buf := make([]byte, 64*1024)
+	_, err := w.file.Read(buf)
+	return err
+}
+
+func (w *inotifyWatcher) close() error {
+	return w.file.Close()
+}
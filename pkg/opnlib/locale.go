@@ -0,0 +1,56 @@
+package opnlib
+
+import (
+	"os"
+	"strings"
+)
+
+// GetMessagesLocale returns the locale used for messages, taken from the first non-empty value of
+// LC_ALL, LC_MESSAGES, and LANG. An empty string is returned if none are set.
+func GetMessagesLocale() string {
+	for _, key := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// getLocaleCandidates returns the keys to look up for the locale, in order of preference,
+// following the matching rules of the desktop entry spec. The encoding is ignored.
+// E.g. sr_YU.UTF-8@Latn results in sr_YU@Latn, sr_YU, sr@Latn, and sr.
+func getLocaleCandidates(locale string) []string {
+	locale, modifier, hasModifier := strings.Cut(locale, "@")
+	locale, _, _ = strings.Cut(locale, ".")
+	lang, country, hasCountry := strings.Cut(locale, "_")
+
+	if lang == "" || lang == "C" || lang == "POSIX" {
+		return nil
+	}
+
+	var result []string
+	if hasCountry && hasModifier {
+		result = append(result, lang+"_"+country+"@"+modifier)
+	}
+	if hasCountry {
+		result = append(result, lang+"_"+country)
+	}
+	if hasModifier {
+		result = append(result, lang+"@"+modifier)
+	}
+
+	return append(result, lang)
+}
+
+// Get returns the value for the locale, e.g. nl_BE.UTF-8, falling back to less specific locales
+// and finally to the default value. See GetMessagesLocale.
+func (s LocalizedString) Get(locale string) string {
+	for _, candidate := range getLocaleCandidates(locale) {
+		if value, ok := s.Localized[candidate]; ok {
+			return value
+		}
+	}
+
+	return s.Default
+}
@@ -3,7 +3,11 @@ package opnlib
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"mime"
+	"net/http"
+	"os"
 	"os/exec"
 	"strings"
 )
@@ -11,6 +15,31 @@ import (
 // GetFileMime returns the mime type of the given path.
 // It uses programs installed
 func GetFileMime(path string) (string, error) {
+	output, err := getXdgMimeFileType(path)
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		// xdg-mime not found in PATH. Fall back to file.
+	case err != nil:
+		return "", err
+	default:
+		return output, nil
+	}
+
+	output, err = getFileCommandMime(path)
+	switch {
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return "", fmt.Errorf("failed to determine MIME type, no programs to determine" +
+			" MIME type are installed. Either xdg-mime (xdg-utils) or, file, is required")
+	case err != nil:
+		return "", err
+	default:
+		return output, nil
+	}
+}
+
+// getXdgMimeFileType returns the MIME type reported by xdg-mime.
+// If xdg-mime is not installed, an error wrapping exec.ErrNotFound is returned.
+func getXdgMimeFileType(path string) (string, error) {
 	xdgCmd := exec.Command("xdg-mime", "query", "filetype", path)
 	output, err := xdgCmd.Output()
 
@@ -18,7 +47,7 @@ func GetFileMime(path string) (string, error) {
 
 	switch {
 	case errors.Is(err, exec.ErrNotFound):
-		// xdg-mime not found in PATH. Fall back to file.
+		return "", err
 	case errors.As(err, &exitError):
 		switch exitError.ExitCode() {
 		case 2:
@@ -27,10 +56,16 @@ func GetFileMime(path string) (string, error) {
 		default:
 			return "", fmt.Errorf("xdg-mime exited with %d: %s", exitError.ExitCode(), string(output))
 		}
+	case err != nil:
+		return "", err
 	default:
 		return strings.TrimSpace(string(output)), nil
 	}
+}
 
+// getFileCommandMime returns the MIME type reported by the file command.
+// If file is not installed, an error wrapping exec.ErrNotFound or fs.ErrNotExist is returned.
+func getFileCommandMime(path string) (string, error) {
 	fileCmd := exec.Command(
 		"/usr/bin/file",
 		"-E",            // Exit with non-zero exit code on filesystem errors
@@ -39,12 +74,11 @@ func GetFileMime(path string) (string, error) {
 		"--mime-type",   // Print MIME type only
 		path,
 	)
-	output, err = fileCmd.Output()
+	output, err := fileCmd.Output()
+
+	var exitError *exec.ExitError
 
 	switch {
-	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
-		return "", fmt.Errorf("failed to determine MIME type, no programs to determine" +
-			" MIME type are installed. Either xdg-mime (xdg-utils) or, file, is required")
 	case errors.As(err, &exitError):
 		return "", fmt.Errorf("file exited with %d: %s", exitError.ExitCode(), string(output))
 	case err != nil:
@@ -53,3 +87,39 @@ func GetFileMime(path string) (string, error) {
 		return strings.TrimSpace(string(output)), nil
 	}
 }
+
+// getMagicMime sniffs the MIME type based on the first bytes of the file's content.
+// An empty string is returned if the content is not recognized.
+func getMagicMime(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	result := StripMimeParameters(http.DetectContentType(buf[:n]))
+	if result == "application/octet-stream" {
+		// The fallback of DetectContentType for unrecognized content
+		return "", nil
+	}
+
+	return result, nil
+}
+
+// StripMimeParameters removes parameters such as charset from a media type.
+// E.g. "text/html; charset=utf-8" becomes "text/html".
+func StripMimeParameters(mediaType string) string {
+	parsed, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		mediaType, _, _ = strings.Cut(mediaType, ";")
+		return strings.TrimSpace(mediaType)
+	}
+
+	return parsed
+}
@@ -0,0 +1,353 @@
+package opnlib
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"github.com/MatthiasKunnen/xdg/basedir"
+	"github.com/MatthiasKunnen/xdg/desktop"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	SectionDefaultApplications = "Default Applications"
+	SectionAddedAssociations   = "Added Associations"
+	SectionRemovedAssociations = "Removed Associations"
+
+	// SectionMimeType is not a mimeapps.list section. It is used when an association originates
+	// from the MimeType key of a desktop file.
+	SectionMimeType = "MimeType"
+)
+
+// mimeappsList holds the contents of a single mimeapps.list file.
+// Key=section, Value=map of Key=MIME type, Value=desktop IDs.
+type mimeappsList map[string]map[string][]string
+
+// GetMimeappsListPaths returns the paths where mimeapps.list files can be located, in order of
+// highest to lowest priority. The files do not necessarily exist.
+// See https://specifications.freedesktop.org/mime-apps-spec/1.0.1/file.html.
+func GetMimeappsListPaths(currentDesktop string) []string {
+	var desktops []string
+	for _, d := range strings.Split(currentDesktop, ":") {
+		if d != "" {
+			desktops = append(desktops, strings.ToLower(d))
+		}
+	}
+
+	var dirs []string
+	dirs = append(dirs, basedir.ConfigHome)
+	dirs = append(dirs, basedir.ConfigDirs...)
+	dirs = append(dirs, path.Join(basedir.DataHome, "applications"))
+	for _, dataDir := range basedir.DataDirs {
+		dirs = append(dirs, path.Join(dataDir, "applications"))
+	}
+
+	result := make([]string, 0, len(dirs)*(len(desktops)+1))
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+
+		for _, d := range desktops {
+			result = append(result, path.Join(dir, d+"-mimeapps.list"))
+		}
+		result = append(result, path.Join(dir, "mimeapps.list"))
+	}
+
+	return result
+}
+
+// getCurrentMimeappsListPaths returns the mimeapps.list paths of the current desktop. It is the
+// only source of the paths that are read to generate the associations, that are checked to
+// determine whether the index is up-to-date, and that are checked by Doctor.
+func getCurrentMimeappsListPaths() []string {
+	return GetMimeappsListPaths(os.Getenv("XDG_CURRENT_DESKTOP"))
+}
+
+// mimeappsListIssue is a line of a mimeapps.list file that is ignored because it is malformed or
+// part of an unknown group.
+type mimeappsListIssue struct {
+	line    int
+	message string
+}
+
+// parseMimeappsList parses a mimeapps.list file. Lines that cannot be parsed and groups other than
+// the ones of the spec are skipped and returned as issues. An error is only returned if the file
+// cannot be read.
+func parseMimeappsList(filename string) (mimeappsList, []mimeappsListIssue, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	result := make(mimeappsList)
+	var issues []mimeappsListIssue
+	var section map[string][]string
+	inUnknownGroup := false
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			name := line[1 : len(line)-1]
+			switch name {
+			case SectionDefaultApplications, SectionAddedAssociations, SectionRemovedAssociations:
+				section = result[name]
+				if section == nil {
+					section = make(map[string][]string)
+					result[name] = section
+				}
+				inUnknownGroup = false
+			default:
+				issues = append(issues, mimeappsListIssue{
+					line:    lineNumber,
+					message: fmt.Sprintf("unknown group [%s]", name),
+				})
+				section = nil
+				inUnknownGroup = true
+			}
+			continue
+		case inUnknownGroup:
+			continue
+		}
+
+		mime, value, found := strings.Cut(line, "=")
+		mime = strings.TrimSpace(mime)
+		var message string
+		switch {
+		case section == nil:
+			message = "entry outside of a group"
+		case !found || mime == "":
+			message = "expected MIME type=desktop IDs"
+		}
+
+		if message != "" {
+			issues = append(issues, mimeappsListIssue{
+				line:    lineNumber,
+				message: fmt.Sprintf("%s: %s", message, line),
+			})
+			continue
+		}
+
+		for _, desktopId := range strings.Split(value, ";") {
+			desktopId = strings.TrimSpace(desktopId)
+			if desktopId != "" {
+				section[mime] = append(section[mime], desktopId)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading %s: %w", filename, err)
+	}
+
+	return result, issues, nil
+}
+
+type loadedMimeappsList struct {
+	path string
+	list mimeappsList
+}
+
+// loadMimeappsLists parses the mimeapps.list files, keeping their order. Files that do not exist
+// or cannot be read are skipped, as are the lines with issues.
+func loadMimeappsLists(paths []string) []loadedMimeappsList {
+	result := make([]loadedMimeappsList, 0, len(paths))
+	for _, listPath := range paths {
+		list, _, err := parseMimeappsList(listPath)
+		if err != nil {
+			continue
+		}
+
+		result = append(result, loadedMimeappsList{
+			path: listPath,
+			list: list,
+		})
+	}
+
+	return result
+}
+
+// associationOrigin is the file and section that associated a desktop ID with a MIME type.
+type associationOrigin struct {
+	file    string
+	section string
+}
+
+// mergedAssociations is the result of mergeAssociations.
+type mergedAssociations struct {
+	// associations maps a lowercase MIME type to the desktop IDs in order of preference.
+	associations map[string][]string
+
+	// origins maps a lowercase MIME type and desktop ID to the origin of the association.
+	origins map[string]map[string]associationOrigin
+}
+
+func (m *mergedAssociations) add(mime string, desktopId string, origin associationOrigin) {
+	if slices.Contains(m.associations[mime], desktopId) {
+		return
+	}
+
+	m.associations[mime] = append(m.associations[mime], desktopId)
+	if m.origins[mime] == nil {
+		m.origins[mime] = make(map[string]associationOrigin)
+	}
+	m.origins[mime][desktopId] = origin
+}
+
+// mergeAssociations combines the mimeapps.list files, in order of highest to lowest priority,
+// with the MimeType key of the desktop entries. Only installed desktop IDs are associated.
+// See https://specifications.freedesktop.org/mime-apps-spec/1.0.1/associations.html and
+// https://specifications.freedesktop.org/mime-apps-spec/1.0.1/default.html.
+//
+// The default application of a MIME type is the first installed desktop ID of the highest
+// priority list that has a default for it. It is followed by the added associations and the
+// desktop entries whose MimeType contains the MIME type. Removed associations apply to the added
+// associations of lower priority lists and to all desktop entries.
+// Desktop entries are ordered by the precedence of the desktopDirs they are found in, then by
+// desktop ID. Aliases are resolved using the aliases of the shared-mime-info database.
+func mergeAssociations(
+	lists []loadedMimeappsList,
+	idToPaths desktop.IdPathMap,
+	entries map[string]DesktopEntry,
+	desktopDirs []string,
+	aliases map[string]string,
+) mergedAssociations {
+	isInstalled := func(desktopId string) bool {
+		return len(idToPaths[desktopId]) > 0
+	}
+
+	canonical := func(mime string) string {
+		mime = strings.ToLower(mime)
+		if target, ok := aliases[mime]; ok {
+			return strings.ToLower(target)
+		}
+
+		return mime
+	}
+
+	// The MIME types of a group in order, the canonical MIME types first so that they take
+	// precedence over aliases of the same MIME type
+	sortedMimes := func(group map[string][]string) []string {
+		mimes := slices.Collect(maps.Keys(group))
+		slices.SortFunc(mimes, func(a, b string) int {
+			_, aIsAlias := aliases[strings.ToLower(a)]
+			_, bIsAlias := aliases[strings.ToLower(b)]
+			if aIsAlias != bIsAlias {
+				if aIsAlias {
+					return 1
+				}
+				return -1
+			}
+
+			return strings.Compare(a, b)
+		})
+
+		return mimes
+	}
+
+	defaults := mergedAssociations{
+		associations: make(map[string][]string),
+		origins:      make(map[string]map[string]associationOrigin),
+	}
+	added := mergedAssociations{
+		associations: make(map[string][]string),
+		origins:      make(map[string]map[string]associationOrigin),
+	}
+	removed := make(map[string][]string)
+
+	for _, l := range lists {
+		defaultApplications := l.list[SectionDefaultApplications]
+		for _, listMime := range sortedMimes(defaultApplications) {
+			mime := canonical(listMime)
+			if len(defaults.associations[mime]) > 0 {
+				continue
+			}
+
+			for _, desktopId := range defaultApplications[listMime] {
+				if isInstalled(desktopId) {
+					defaults.add(mime, desktopId, associationOrigin{
+						file:    l.path,
+						section: SectionDefaultApplications,
+					})
+					break
+				}
+			}
+		}
+
+		addedAssociations := l.list[SectionAddedAssociations]
+		for _, listMime := range sortedMimes(addedAssociations) {
+			mime := canonical(listMime)
+			for _, desktopId := range addedAssociations[listMime] {
+				if isInstalled(desktopId) && !slices.Contains(removed[mime], desktopId) {
+					added.add(mime, desktopId, associationOrigin{
+						file:    l.path,
+						section: SectionAddedAssociations,
+					})
+				}
+			}
+		}
+
+		// Removals only affect lists of lower priority, the next iterations
+		for mime, desktopIds := range l.list[SectionRemovedAssociations] {
+			mime = canonical(mime)
+			removed[mime] = append(removed[mime], desktopIds...)
+		}
+	}
+
+	// The directory of the desktop file that is used, or len(desktopDirs) if it is in none of them
+	dirRank := func(desktopId string) int {
+		paths := idToPaths[desktopId]
+		if len(paths) == 0 {
+			return len(desktopDirs)
+		}
+
+		for i, dir := range desktopDirs {
+			if strings.HasPrefix(paths[0], strings.TrimSuffix(dir, "/")+"/") {
+				return i
+			}
+		}
+
+		return len(desktopDirs)
+	}
+
+	desktopIds := slices.Collect(maps.Keys(entries))
+	slices.SortFunc(desktopIds, func(a, b string) int {
+		return cmp.Or(cmp.Compare(dirRank(a), dirRank(b)), strings.Compare(a, b))
+	})
+
+	for _, desktopId := range desktopIds {
+		entry := entries[desktopId]
+		if entry.Hidden {
+			continue // Hidden entries are considered deleted
+		}
+
+		for _, mime := range entry.MimeType {
+			mime = canonical(mime)
+			if !slices.Contains(removed[mime], desktopId) {
+				added.add(mime, desktopId, associationOrigin{
+					file:    entry.FilePath,
+					section: SectionMimeType,
+				})
+			}
+		}
+	}
+
+	for mime, desktopIds := range added.associations {
+		for _, desktopId := range desktopIds {
+			defaults.add(mime, desktopId, added.origins[mime][desktopId])
+		}
+	}
+
+	return defaults
+}
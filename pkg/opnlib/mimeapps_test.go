@@ -0,0 +1,172 @@
+package opnlib
+
+import (
+	"github.com/MatthiasKunnen/xdg/desktop"
+	"reflect"
+	"testing"
+)
+
+func TestMergeAssociations(t *testing.T) {
+	const (
+		userDir   = "/home/user/.local/share/applications"
+		systemDir = "/usr/share/applications"
+	)
+	desktopDirs := []string{userDir, systemDir}
+	aliases := map[string]string{"application/x-pdf": "application/pdf"}
+
+	// user.desktop and zeta.desktop are installed in the directory of highest precedence
+	idToPaths := desktop.IdPathMap{
+		"alpha.desktop": {systemDir + "/alpha.desktop"},
+		"beta.desktop":  {systemDir + "/beta.desktop"},
+		"user.desktop":  {userDir + "/user.desktop"},
+		"zeta.desktop":  {userDir + "/zeta.desktop", systemDir + "/zeta.desktop"},
+	}
+
+	entriesFor := func(mime string, desktopIds ...string) map[string]DesktopEntry {
+		entries := make(map[string]DesktopEntry)
+		for _, desktopId := range desktopIds {
+			entries[desktopId] = DesktopEntry{
+				FilePath: idToPaths[desktopId][0],
+				MimeType: []string{mime},
+			}
+		}
+		return entries
+	}
+
+	list := func(path string, sections map[string]map[string][]string) loadedMimeappsList {
+		return loadedMimeappsList{path: path, list: sections}
+	}
+
+	tests := []struct {
+		name     string
+		lists    []loadedMimeappsList
+		entries  map[string]DesktopEntry
+		mime     string
+		expected []string
+	}{
+		{
+			name: "MimeType ordered by directory precedence",
+			entries: entriesFor(
+				"text/plain",
+				"alpha.desktop",
+				"beta.desktop",
+				"user.desktop",
+				"zeta.desktop",
+			),
+			mime:     "text/plain",
+			expected: []string{"user.desktop", "zeta.desktop", "alpha.desktop", "beta.desktop"},
+		},
+		{
+			name: "hidden entries are not associated",
+			entries: map[string]DesktopEntry{
+				"alpha.desktop": {MimeType: []string{"text/plain"}, Hidden: true},
+				"beta.desktop":  {MimeType: []string{"text/plain"}},
+			},
+			mime:     "text/plain",
+			expected: []string{"beta.desktop"},
+		},
+		{
+			name: "default of the highest priority list",
+			lists: []loadedMimeappsList{
+				list("high", map[string]map[string][]string{
+					SectionDefaultApplications: {
+						"text/plain": {"missing.desktop", "beta.desktop"},
+					},
+				}),
+				list("low", map[string]map[string][]string{
+					SectionDefaultApplications: {"text/plain": {"alpha.desktop"}},
+				}),
+			},
+			entries:  entriesFor("text/plain", "alpha.desktop", "user.desktop"),
+			mime:     "text/plain",
+			expected: []string{"beta.desktop", "user.desktop", "alpha.desktop"},
+		},
+		{
+			name: "added associations before MimeType",
+			lists: []loadedMimeappsList{
+				list("high", map[string]map[string][]string{
+					SectionAddedAssociations: {"text/plain": {"zeta.desktop"}},
+				}),
+				list("low", map[string]map[string][]string{
+					SectionAddedAssociations: {
+						"text/plain": {"missing.desktop", "beta.desktop", "zeta.desktop"},
+					},
+				}),
+			},
+			entries:  entriesFor("text/plain", "alpha.desktop", "beta.desktop"),
+			mime:     "text/plain",
+			expected: []string{"zeta.desktop", "beta.desktop", "alpha.desktop"},
+		},
+		{
+			name: "removed associations apply to lower priority lists and MimeType",
+			lists: []loadedMimeappsList{
+				list("high", map[string]map[string][]string{
+					SectionAddedAssociations:   {"text/plain": {"zeta.desktop"}},
+					SectionRemovedAssociations: {"text/plain": {"beta.desktop", "user.desktop"}},
+				}),
+				list("low", map[string]map[string][]string{
+					SectionAddedAssociations:   {"text/plain": {"beta.desktop"}},
+					SectionRemovedAssociations: {"text/plain": {"zeta.desktop"}},
+				}),
+			},
+			entries:  entriesFor("text/plain", "alpha.desktop", "user.desktop"),
+			mime:     "text/plain",
+			expected: []string{"zeta.desktop", "alpha.desktop"},
+		},
+		{
+			name: "MIME types are case-insensitive",
+			lists: []loadedMimeappsList{
+				list("high", map[string]map[string][]string{
+					SectionDefaultApplications: {"Text/Plain": {"beta.desktop"}},
+					SectionRemovedAssociations: {"TEXT/plain": {"alpha.desktop"}},
+				}),
+			},
+			entries:  entriesFor("text/PLAIN", "alpha.desktop", "user.desktop"),
+			mime:     "text/plain",
+			expected: []string{"beta.desktop", "user.desktop"},
+		},
+		{
+			name: "aliases are merged with their canonical MIME type",
+			lists: []loadedMimeappsList{
+				list("high", map[string]map[string][]string{
+					SectionAddedAssociations:   {"application/x-pdf": {"zeta.desktop"}},
+					SectionRemovedAssociations: {"application/x-pdf": {"beta.desktop"}},
+				}),
+				list("low", map[string]map[string][]string{
+					SectionDefaultApplications: {"application/x-pdf": {"alpha.desktop"}},
+				}),
+			},
+			entries:  entriesFor("application/x-pdf", "beta.desktop", "user.desktop"),
+			mime:     "application/pdf",
+			expected: []string{"alpha.desktop", "zeta.desktop", "user.desktop"},
+		},
+		{
+			name: "canonical MIME type takes precedence over its alias",
+			lists: []loadedMimeappsList{
+				list("high", map[string]map[string][]string{
+					SectionDefaultApplications: {
+						"application/x-pdf": {"alpha.desktop"},
+						"application/pdf":   {"beta.desktop"},
+					},
+				}),
+			},
+			mime:     "application/pdf",
+			expected: []string{"beta.desktop"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := mergeAssociations(tt.lists, idToPaths, tt.entries, desktopDirs, aliases)
+			if got := merged.associations[tt.mime]; !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+
+			for mime := range aliases {
+				if _, ok := merged.associations[mime]; ok {
+					t.Errorf("expected the alias %s to be resolved", mime)
+				}
+			}
+		})
+	}
+}
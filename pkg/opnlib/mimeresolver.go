@@ -0,0 +1,181 @@
+package opnlib
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pkg/xattr"
+	"io/fs"
+	"os"
+	"os/exec"
+)
+
+// MimeStage is a method used to determine the MIME type of a file.
+type MimeStage string
+
+const (
+	// MimeStageOverride is the MIME type given explicitly, e.g. using --mime-type.
+	MimeStageOverride MimeStage = "override"
+	// MimeStageXattr is the value of the user.mime extended file attribute.
+	MimeStageXattr MimeStage = "xattr"
+	// MimeStageXdgMime is the output of xdg-mime query filetype.
+	MimeStageXdgMime MimeStage = "xdg-mime"
+	// MimeStageFile is the output of the file command.
+	MimeStageFile MimeStage = "file"
+	// MimeStageGlob is the result of matching the file name against the shared-mime-info globs.
+	MimeStageGlob MimeStage = "glob"
+	// MimeStageMagic is the result of sniffing the content of the file.
+	MimeStageMagic MimeStage = "magic"
+)
+
+// MimeStageResult is the outcome of a single MIME stage.
+type MimeStageResult struct {
+	Stage MimeStage
+
+	// Mime is the MIME type produced by the stage, empty if it produced none.
+	Mime string
+
+	// Error is set when the stage failed.
+	Error string
+
+	// Skipped is true when the stage was not executed, e.g. because a previous stage already
+	// determined the MIME type.
+	Skipped bool
+}
+
+// MimeResolution is the result of resolving the MIME type of a file.
+type MimeResolution struct {
+	Mime string
+
+	// Stage is the stage that determined the MIME type.
+	Stage MimeStage
+
+	// Stages contains the result of every stage, in the order they are tried.
+	Stages []MimeStageResult
+}
+
+// MimeResolver determines the MIME type of a file.
+// The stages are tried in this order, the first one to produce a MIME type wins:
+//  1. Override
+//  2. The user.mime extended file attribute
+//  3. xdg-mime
+//  4. file
+//  5. The shared-mime-info globs
+//  6. Sniffing the content of the file, unrecognized content does not produce a MIME type
+type MimeResolver struct {
+	// Override is used as MIME type if it is not empty.
+	Override string
+
+	// SkipXattr skips reading the user.mime extended file attribute, e.g. for downloaded files.
+	SkipXattr bool
+
+	// TryAll executes all stages, even after the MIME type has been determined. This is useful
+	// to explain how the MIME type was determined.
+	TryAll bool
+
+	// Globs are used for the glob stage. If nil, they are loaded from the shared-mime-info
+	// database. Use Opn.GetMimeGlobs to use the cached globs.
+	Globs []MimeGlob
+
+	// MatchGlob, if set, is used for the glob stage instead of Globs. Use Opn.MatchMimeGlob to
+	// match using the cache or daemon without transferring all globs.
+	MatchGlob func(filePath string) string
+}
+
+// Resolve determines the MIME type of the file at the given path.
+func (r MimeResolver) Resolve(filePath string) (MimeResolution, error) {
+	var result MimeResolution
+
+	if r.Override == "" {
+		if _, err := os.Stat(filePath); err != nil {
+			return result, err
+		}
+	}
+
+	stages := []struct {
+		stage MimeStage
+		get   func() (string, error)
+	}{
+		{MimeStageOverride, func() (string, error) {
+			return r.Override, nil
+		}},
+		{MimeStageXattr, func() (string, error) {
+			if r.SkipXattr {
+				return "", nil
+			}
+
+			attrMime, err := xattr.Get(filePath, "user.mime")
+			if errors.Is(err, xattr.ENOATTR) {
+				return "", nil
+			}
+
+			return string(attrMime), err
+		}},
+		{MimeStageXdgMime, func() (string, error) {
+			return getXdgMimeFileType(filePath)
+		}},
+		{MimeStageFile, func() (string, error) {
+			return getFileCommandMime(filePath)
+		}},
+		{MimeStageGlob, func() (string, error) {
+			if r.MatchGlob != nil {
+				return r.MatchGlob(filePath), nil
+			}
+
+			globs := r.Globs
+			if globs == nil {
+				var err error
+				globs, err = loadGlobs()
+				if err != nil {
+					return "", err
+				}
+			}
+
+			return matchGlobs(globs, filePath), nil
+		}},
+		{MimeStageMagic, func() (string, error) {
+			return getMagicMime(filePath)
+		}},
+	}
+
+	missingPrograms := 0
+	for _, s := range stages {
+		stageResult := MimeStageResult{
+			Stage: s.stage,
+		}
+
+		if result.Mime != "" && !r.TryAll {
+			stageResult.Skipped = true
+			result.Stages = append(result.Stages, stageResult)
+			continue
+		}
+
+		mime, err := s.get()
+		if (s.stage == MimeStageXdgMime || s.stage == MimeStageFile) &&
+			(errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist)) {
+			missingPrograms++
+		}
+
+		if err != nil {
+			stageResult.Error = err.Error()
+		} else {
+			stageResult.Mime = mime
+		}
+
+		if result.Mime == "" && mime != "" && err == nil {
+			result.Mime = mime
+			result.Stage = s.stage
+		}
+
+		result.Stages = append(result.Stages, stageResult)
+	}
+
+	switch {
+	case result.Mime == "" && missingPrograms == 2:
+		return result, fmt.Errorf("failed to determine MIME type of %s, no programs to determine"+
+			" MIME type are installed. Either xdg-mime (xdg-utils) or, file, is required", filePath)
+	case result.Mime == "":
+		return result, fmt.Errorf("failed to determine MIME type of %s", filePath)
+	}
+
+	return result, nil
+}
@@ -1,35 +1,73 @@
 package opnlib
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/MatthiasKunnen/xdg/basedir"
-	"github.com/MatthiasKunnen/xdg/sharedmimeinfo"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
 	"path"
+	"slices"
+	"strings"
 	"time"
 )
 
 type Opn struct {
 	// CacheFilePath is either an absolute path to the cache file or a path relative to the cache
-	// dir. Leave empty to use the default.
+	// dir. Leave empty to use the default. If the path has the .json extension, the cache is
+	// stored as JSON instead of the faster binary format.
 	CacheFilePath string
 
 	// index holds the lookup maps for associations and desktop IDs.
 	index *Index
 
-	// SkipCache determines whether loading the cache is skipped.
+	// SkipCache determines whether loading the cache is skipped. The daemon is not used either.
 	SkipCache bool
 
-	mimeSubclassInfo *sharedmimeinfo.Subclass
+	// SkipDaemon disables performing lookups using a running Daemon.
+	SkipDaemon bool
+
+	// daemon is set when lookups are performed by the daemon, index is nil in that case.
+	daemon *daemonClient
+
+	// MaxCacheAge is the maximum age of the cache, after which it is regenerated completely, even
+	// if no changes were detected. If 0, the OPN_CACHE_MAX_AGE environment variable is used, or
+	// DefaultMaxCacheAge if it is not set. A negative value disables the age check.
+	MaxCacheAge time.Duration
 }
 
 var (
 	FailedToSaveCache = errors.New("failed to save cache")
 )
 
-// Load loads the cache and generates it if necessary.
+// DefaultMaxCacheAge is the default maximum age of the cache.
+const DefaultMaxCacheAge = 24 * time.Hour
+
+// Load connects to the daemon if it is running, otherwise it loads the cache and generates it if
+// necessary.
+// Unless SkipCache is set, a regenerated cache is saved immediately. Regeneration is guarded by an
+// advisory lock, this makes concurrent regenerations by multiple processes collapse into one.
+// If the index is loaded but saving it fails, an error wrapping FailedToSaveCache is returned.
 func (opn *Opn) Load() error {
-	filename := opn.getCachePath()
+	socketPath := GetDaemonSocketPath()
+	if !opn.SkipCache && !opn.SkipDaemon && socketPath != "" {
+		client, err := dialDaemon(socketPath, opn.GetCachePath())
+		if err == nil {
+			opn.daemon = client
+			return nil
+		}
+	}
+
+	return opn.loadIndex()
+}
+
+// loadIndex loads the cache and generates it if necessary, see Load.
+func (opn *Opn) loadIndex() error {
+	filename := opn.GetCachePath()
 
 	if opn.SkipCache {
 		index, err := GenerateIndex()
@@ -41,24 +79,102 @@ func (opn *Opn) Load() error {
 		return nil
 	}
 
+	maxCacheAge, err := opn.getMaxCacheAge()
+	if err != nil {
+		return err
+	}
+
+	// Fast path, no lock is needed if the cache is up-to-date
 	index, err := LoadIndex(filename)
-	if err == nil && index.GeneratedOn.Add(24*time.Hour).After(time.Now()) {
-		opn.index = index
-	} else {
-		index, err = GenerateIndex()
+	if err != nil || !index.isUpToDate(maxCacheAge) {
+		if index != nil {
+			index.release()
+		}
+
+		unlock, lockErr := lockCache(filename)
+		if lockErr == nil {
+			defer unlock()
+		} else {
+			log.Printf("Failed to lock cache, continuing without lock: %v\n", lockErr)
+		}
+
+		// Another process might have updated the cache while waiting for the lock
+		index, err = opn.loadOrGenerateIndex(filename, maxCacheAge)
 		if err != nil {
-			return fmt.Errorf("failed to generate index: %w", err)
+			return err
 		}
+	}
 
-		opn.index = index
+	opn.index = index
+
+	if index.needsSave {
+		return opn.SaveIndex()
+	}
+
+	return nil
+}
+
+// loadOrGenerateIndex loads the index from the cache and refreshes it, or generates it if the cache
+// cannot be loaded or is too old.
+func (opn *Opn) loadOrGenerateIndex(filename string, maxCacheAge time.Duration) (*Index, error) {
+	index, err := LoadIndex(filename)
+	if errors.Is(err, ErrIndexVersionMismatch) {
+		log.Printf("Ignoring cache: %v. Regenerating.\n", err)
+	}
+
+	if err == nil && !index.isExpired(maxCacheAge) {
+		_, err = index.Refresh()
+		if err != nil {
+			return nil, fmt.Errorf("failed to refresh index: %w", err)
+		}
+
+		return index, nil
+	}
+
+	if index != nil {
+		index.release()
 	}
 
-	opn.mimeSubclassInfo, err = sharedmimeinfo.LoadFromOs()
+	index, err = GenerateIndex()
 	if err != nil {
-		return fmt.Errorf("failed to load subclass info: %w", err)
+		return nil, fmt.Errorf("failed to generate index: %w", err)
 	}
 
-	return nil
+	return index, nil
+}
+
+// Regenerate generates the index from the file system and saves the cache. If a daemon is running,
+// and SkipDaemon is not set, the daemon regenerates its index instead and lookups are performed
+// using the daemon. The cache is not read.
+func (opn *Opn) Regenerate() error {
+	socketPath := GetDaemonSocketPath()
+	if !opn.SkipDaemon && socketPath != "" {
+		client, err := dialDaemon(socketPath, opn.GetCachePath())
+		if err == nil {
+			opn.daemon = client
+			if _, ok, err := fromDaemon[bool](opn, daemonRegenerate); ok {
+				return err
+			}
+		}
+	}
+
+	// Locked like a regeneration by Load so that concurrent regenerations do not overwrite each
+	// other
+	unlock, err := lockCache(opn.GetCachePath())
+	if err == nil {
+		defer unlock()
+	} else {
+		log.Printf("Failed to lock cache, continuing without lock: %v\n", err)
+	}
+
+	index, err := GenerateIndex()
+	if err != nil {
+		return fmt.Errorf("failed to generate index: %w", err)
+	}
+
+	opn.index = index
+
+	return opn.SaveIndex()
 }
 
 // LoadAndSave attempts to load the cache and saves it if necessary.
@@ -68,7 +184,7 @@ func (opn *Opn) LoadAndSave() error {
 		return err
 	}
 
-	if opn.index.isNewlyGenerated {
+	if opn.index != nil && opn.index.needsSave {
 		err = opn.SaveIndex()
 		if err != nil {
 			return err
@@ -78,6 +194,19 @@ func (opn *Opn) LoadAndSave() error {
 	return nil
 }
 
+// Close releases the memory-mapped index and closes the connection to the daemon. The Opn must not
+// be used afterwards.
+func (opn *Opn) Close() {
+	if opn.index != nil {
+		opn.index.release()
+	}
+
+	if opn.daemon != nil {
+		opn.daemon.close()
+		opn.daemon = nil
+	}
+}
+
 type MimeDesktopIds struct {
 	Mime       string
 	DesktopIds []string
@@ -85,8 +214,15 @@ type MimeDesktopIds struct {
 
 // GetDesktopIdsForBroadMime returns all desktop IDs for a given mime type and all its subtypes.
 // E.g. text/html will also return results for text/plain.
+// The mime type is first resolved to its canonical form, see CanonicalMime.
 // The results are in order of higher priority to lower priority.
 func (opn *Opn) GetDesktopIdsForBroadMime(mimeType string) []MimeDesktopIds {
+	remote, ok, _ := fromDaemon[[]MimeDesktopIds](opn, "GetDesktopIdsForBroadMime", mimeType)
+	if ok {
+		return remote
+	}
+
+	mimeType = opn.CanonicalMime(mimeType)
 	result := []MimeDesktopIds{
 		{
 			Mime:       mimeType,
@@ -94,7 +230,7 @@ func (opn *Opn) GetDesktopIdsForBroadMime(mimeType string) []MimeDesktopIds {
 		},
 	}
 
-	broaderMime := opn.mimeSubclassInfo.BroaderDfs(mimeType)
+	broaderMime := opn.index.MimeInfo.broaderDfs(mimeType)
 	for _, mime := range broaderMime {
 		result = append(result, MimeDesktopIds{
 			Mime:       mime,
@@ -108,18 +244,99 @@ func (opn *Opn) GetDesktopIdsForBroadMime(mimeType string) []MimeDesktopIds {
 // GetDesktopIdsForMime returns all desktop IDs for a given mime type.
 // The results are in order of higher priority to lower priority.
 func (opn *Opn) GetDesktopIdsForMime(mimeType string) []string {
-	associations := opn.index.Associations[mimeType]
+	if result, ok, _ := fromDaemon[[]string](opn, "GetDesktopIdsForMime", mimeType); ok {
+		return result
+	}
+
+	// MIME types are case-insensitive, the index only contains lowercase MIME types
+	associations, _ := opn.index.lookupAssociations(strings.ToLower(mimeType))
 	associationsCopy := make([]string, len(associations))
 	copy(associationsCopy, associations)
 
 	return associationsCopy
 }
 
+// CanonicalMime resolves the MIME type if it is an alias and returns it as spelled in the
+// shared-mime-info database. E.g. application/x-pdf and Application/PDF become application/pdf.
+// MIME types that are not in the database are returned as is.
+func (opn *Opn) CanonicalMime(mimeType string) string {
+	if result, ok, _ := fromDaemon[string](opn, "CanonicalMime", mimeType); ok {
+		return result
+	}
+
+	mimeType = strings.TrimSpace(mimeType)
+	lowerMime := strings.ToLower(mimeType)
+	if canonical, ok := opn.index.MimeInfo.Aliases[lowerMime]; ok {
+		return canonical
+	}
+
+	if spelling, ok := opn.index.MimeInfo.Types[lowerMime]; ok {
+		return spelling
+	}
+
+	return mimeType
+}
+
+// IsMimePattern returns true if the MIME type contains wildcard characters, e.g. image/*.
+func IsMimePattern(mimeType string) bool {
+	return strings.ContainsAny(mimeType, "*?[")
+}
+
+// GetMimesMatching returns all MIME types known to the index that match the pattern, sorted
+// alphabetically. The pattern is matched case-insensitively using path.Match, e.g. image/*.
+// The MIME types are spelled as in the shared-mime-info database.
+func (opn *Opn) GetMimesMatching(pattern string) ([]string, error) {
+	if result, ok, err := fromDaemon[[]string](opn, "GetMimesMatching", pattern); ok {
+		return result, err
+	}
+
+	pattern = strings.ToLower(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid MIME pattern %s: %w", pattern, err)
+	}
+
+	opn.index.materialize()
+	result := make([]string, 0)
+	for mime := range opn.index.Associations {
+		if matched, _ := path.Match(pattern, mime); !matched {
+			continue
+		}
+
+		result = append(result, opn.index.MimeInfo.spelling(mime))
+	}
+	slices.Sort(result)
+
+	return result, nil
+}
+
 func (opn *Opn) GetDesktopFileLocations(desktopId string) []string {
-	return opn.index.DesktopIdToPaths[desktopId]
+	if result, ok, _ := fromDaemon[[]string](opn, "GetDesktopFileLocations", desktopId); ok {
+		return result
+	}
+
+	return opn.index.lookupDesktopFilePaths(desktopId)
 }
 
-func (opn *Opn) getCachePath() string {
+func (opn *Opn) getMaxCacheAge() (time.Duration, error) {
+	if opn.MaxCacheAge != 0 {
+		return opn.MaxCacheAge, nil
+	}
+
+	envVal := os.Getenv("OPN_CACHE_MAX_AGE")
+	if envVal == "" {
+		return DefaultMaxCacheAge, nil
+	}
+
+	maxCacheAge, err := time.ParseDuration(envVal)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value of OPN_CACHE_MAX_AGE '%s': %w", envVal, err)
+	}
+
+	return maxCacheAge, nil
+}
+
+// GetCachePath returns the absolute path of the cache file.
+func (opn *Opn) GetCachePath() string {
 	var filename = opn.CacheFilePath
 
 	if filename == "" {
@@ -133,15 +350,100 @@ func (opn *Opn) getCachePath() string {
 	return filename
 }
 
+// GetMimeGlobs returns the globs of the shared-mime-info database. The result must not be
+// modified. See MimeResolver.Globs.
+func (opn *Opn) GetMimeGlobs() []MimeGlob {
+	if result, ok, _ := fromDaemon[[]MimeGlob](opn, "GetMimeGlobs"); ok {
+		return result
+	}
+
+	return opn.index.MimeInfo.Globs
+}
+
+// MatchMimeGlob returns the MIME type of the shared-mime-info glob that matches the file name best
+// or an empty string if none match. See MimeResolver.MatchGlob.
+func (opn *Opn) MatchMimeGlob(filePath string) string {
+	if result, ok, _ := fromDaemon[string](opn, "MatchMimeGlob", filePath); ok {
+		return result
+	}
+
+	return matchGlobs(opn.index.MimeInfo.Globs, filePath)
+}
+
+// ExportJson writes the index as JSON.
+func (opn *Opn) ExportJson(w io.Writer) error {
+	if result, ok, err := fromDaemon[json.RawMessage](opn, "ExportJson"); ok {
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(append(result, '\n'))
+		return err
+	}
+
+	return opn.index.WriteJson(w)
+}
+
 func (opn *Opn) SaveIndex() error {
-	err := opn.index.SaveIndex(opn.getCachePath())
+	if opn.daemon != nil {
+		// The daemon saves the index itself
+		return nil
+	}
+
+	err := opn.index.SaveIndex(opn.GetCachePath())
 	if err != nil {
 		return fmt.Errorf("%w: %w", FailedToSaveCache, err)
 	}
 
+	opn.index.needsSave = false
+
+	if opn.GetCachePath() == GetDefaultCachePath() {
+		// The JSON cache of older versions is no longer read
+		err = os.Remove(getLegacyCachePath())
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to remove the old cache: %v\n", err)
+		}
+	}
+
 	return nil
 }
 
 func GetDefaultCachePath() string {
+	return path.Join(basedir.CacheHome, "opn/db.bin")
+}
+
+// getLegacyCachePath returns the default cache path of opn versions that only supported JSON.
+// It is removed when the cache is saved at the default path.
+func getLegacyCachePath() string {
 	return path.Join(basedir.CacheHome, "opn/db.json")
 }
+
+// GetDesktopIds returns all known desktop IDs, sorted alphabetically.
+func (opn *Opn) GetDesktopIds() []string {
+	if result, ok, _ := fromDaemon[[]string](opn, "GetDesktopIds"); ok {
+		return result
+	}
+
+	opn.index.materialize()
+	return slices.Sorted(maps.Keys(opn.index.DesktopIdToPaths))
+}
+
+// GetMimesWithDefault returns the MIME types for which the given desktop ID is the preferred
+// application, sorted alphabetically. The MIME types are spelled as in the shared-mime-info
+// database.
+func (opn *Opn) GetMimesWithDefault(desktopId string) []string {
+	if result, ok, _ := fromDaemon[[]string](opn, "GetMimesWithDefault", desktopId); ok {
+		return result
+	}
+
+	opn.index.materialize()
+	result := make([]string, 0)
+	for mime, desktopIds := range opn.index.Associations {
+		if len(desktopIds) > 0 && desktopIds[0] == desktopId {
+			result = append(result, opn.index.MimeInfo.spelling(mime))
+		}
+	}
+	slices.Sort(result)
+
+	return result
+}
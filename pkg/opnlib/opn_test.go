@@ -0,0 +1,37 @@
+package opnlib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMimeSpelling(t *testing.T) {
+	opn := &Opn{index: &Index{
+		Associations: map[string][]string{
+			"text/plain":   {"editor.desktop"},
+			"text/x-mixed": {"editor.desktop", "viewer.desktop"},
+			"text/x-other": {"viewer.desktop"},
+		},
+		MimeInfo: MimeInfo{
+			Types: map[string]string{
+				"text/plain":   "text/plain",
+				"text/x-mixed": "text/x-Mixed",
+				"text/x-other": "text/x-other",
+			},
+		},
+	}}
+
+	expected := []string{"text/plain", "text/x-Mixed"}
+	if got := opn.GetMimesWithDefault("editor.desktop"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("GetMimesWithDefault: expected %v, got %v", expected, got)
+	}
+
+	matching, err := opn.GetMimesMatching("text/x-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"text/x-Mixed", "text/x-other"}
+	if !reflect.DeepEqual(matching, expected) {
+		t.Errorf("GetMimesMatching: expected %v, got %v", expected, matching)
+	}
+}
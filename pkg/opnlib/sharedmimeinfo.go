@@ -0,0 +1,390 @@
+package opnlib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/xdg/basedir"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MimeGlob is a glob rule of the shared-mime-info database, used to determine the MIME type of a
+// file based on its name.
+type MimeGlob struct {
+	Weight        int
+	Mime          string
+	Pattern       string
+	CaseSensitive bool
+}
+
+// getMimeDirs returns the directories of the shared-mime-info database in order of highest to
+// lowest priority.
+func getMimeDirs() []string {
+	dirs := make([]string, 0, len(basedir.DataDirs)+1)
+	if basedir.DataHome != "" {
+		dirs = append(dirs, path.Join(basedir.DataHome, "mime"))
+	}
+
+	for _, dataDir := range basedir.DataDirs {
+		dirs = append(dirs, path.Join(dataDir, "mime"))
+	}
+
+	return dirs
+}
+
+// loadGlobs loads the globs2 files of the shared-mime-info database.
+// Directories without a globs2 file are skipped.
+func loadGlobs() ([]MimeGlob, error) {
+	var result []MimeGlob
+	for _, dir := range getMimeDirs() {
+		globs, err := parseGlobs2(path.Join(dir, "globs2"))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		result = append(result, globs...)
+	}
+
+	return result, nil
+}
+
+// parseGlobs2 parses a globs2 file.
+// Each line has the format weight:mimetype:glob[:flags].
+// See https://specifications.freedesktop.org/shared-mime-info-spec/0.22/ar01s02.html#id-1.3.5.
+func parseGlobs2(filename string) ([]MimeGlob, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result []MimeGlob
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Split(line, ":")
+		if len(parts) < 3 {
+			continue
+		}
+
+		weight, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+
+		glob := MimeGlob{
+			Weight:  weight,
+			Mime:    parts[1],
+			Pattern: parts[2],
+		}
+
+		if len(parts) > 3 {
+			for _, flag := range strings.Split(parts[3], ",") {
+				if flag == "cs" {
+					glob.CaseSensitive = true
+				}
+			}
+		}
+
+		result = append(result, glob)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", filename, err)
+	}
+
+	return result, nil
+}
+
+// matchGlobs returns the MIME type of the glob that matches the filename best. Higher weights win,
+// after that, the longest pattern wins. An empty string is returned if no glob matches.
+func matchGlobs(globs []MimeGlob, filename string) string {
+	name := filepath.Base(filename)
+	lowerName := strings.ToLower(name)
+
+	var best *MimeGlob
+	for i := range globs {
+		glob := &globs[i]
+		candidate := lowerName
+		pattern := glob.Pattern
+		if glob.CaseSensitive {
+			candidate = name
+		} else {
+			pattern = strings.ToLower(pattern)
+		}
+
+		if matched, err := filepath.Match(pattern, candidate); err != nil || !matched {
+			continue
+		}
+
+		if best == nil ||
+			glob.Weight > best.Weight ||
+			glob.Weight == best.Weight && len(glob.Pattern) > len(best.Pattern) {
+			best = glob
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+
+	return best.Mime
+}
+
+// loadAliases loads the aliases files of the shared-mime-info database.
+// The result maps the lowercase alias to the canonical MIME type as spelled in the database.
+func loadAliases() (map[string]string, error) {
+	result := make(map[string]string)
+	for _, dir := range getMimeDirs() {
+		err := parseAliases(path.Join(dir, "aliases"), result)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		case err != nil:
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// parseAliases parses an aliases file and adds the aliases to the given map. Aliases that are
+// already present are not overwritten, this allows for the files to be parsed in order of priority.
+// Each line has the format "alias canonical".
+func parseAliases(filename string, aliases map[string]string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		alias, canonical, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+
+		alias = strings.ToLower(alias)
+		if _, exists := aliases[alias]; !exists {
+			aliases[alias] = strings.TrimSpace(canonical)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %w", filename, err)
+	}
+
+	return nil
+}
+
+// MimeInfo holds the data of the shared-mime-info database that is used by opn.
+// MIME types are case-insensitive, the keys of the maps are lowercase so that they can be looked
+// up directly. The values are spelled as in the database.
+type MimeInfo struct {
+	// Subclasses maps a MIME type to the MIME types it is a subclass of, e.g. text/html to
+	// text/plain.
+	Subclasses map[string][]string
+
+	// Aliases maps an alias to the canonical MIME type.
+	Aliases map[string]string
+
+	// Types maps the MIME types of the database to their spelling in the database.
+	Types map[string]string
+
+	// Globs are used to determine the MIME type based on a file name.
+	Globs []MimeGlob
+
+	// Sources holds the state of the database files at the time the MimeInfo was loaded.
+	Sources []SourceStat
+}
+
+// spelling returns the lowercase MIME type as spelled in the database, or as is if it is unknown.
+func (info *MimeInfo) spelling(lowerMime string) string {
+	if spelling, ok := info.Types[lowerMime]; ok {
+		return spelling
+	}
+
+	return lowerMime
+}
+
+// getMimeInfoPaths returns the paths of the shared-mime-info database files that are used to
+// load the MimeInfo.
+func getMimeInfoPaths() []string {
+	var result []string
+	for _, dir := range getMimeDirs() {
+		result = append(
+			result,
+			path.Join(dir, "aliases"),
+			path.Join(dir, "globs2"),
+			path.Join(dir, "subclasses"),
+			path.Join(dir, "types"),
+		)
+	}
+
+	return result
+}
+
+// loadMimeInfo loads the MimeInfo from the shared-mime-info database.
+func loadMimeInfo() (MimeInfo, error) {
+	// Stat before reading so that changes made while loading are detected next time
+	result := MimeInfo{
+		Sources:    statSources(getMimeInfoPaths()),
+		Subclasses: make(map[string][]string),
+	}
+
+	var err error
+	result.Aliases, err = loadAliases()
+	if err != nil {
+		return result, fmt.Errorf("failed to load MIME aliases: %w", err)
+	}
+
+	result.Globs, err = loadGlobs()
+	if err != nil {
+		return result, fmt.Errorf("failed to load MIME globs: %w", err)
+	}
+
+	result.Types, _, err = loadMimeTypes()
+	if err != nil {
+		return result, fmt.Errorf("failed to load MIME types: %w", err)
+	}
+
+	for _, dir := range getMimeDirs() {
+		err = parseSubclasses(path.Join(dir, "subclasses"), result.Subclasses)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		case err != nil:
+			return result, fmt.Errorf("failed to load MIME subclasses: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// parseSubclasses parses a subclasses file and adds the parents to the given map. The subclass is
+// lowercased, the parent is kept as spelled in the database.
+// Each line has the format "subclass parent".
+func parseSubclasses(filename string, subclasses map[string][]string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		subclass, parent, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+
+		subclass = strings.ToLower(subclass)
+		parent = strings.TrimSpace(parent)
+		if !slices.ContainsFunc(subclasses[subclass], func(p string) bool {
+			return strings.EqualFold(p, parent)
+		}) {
+			subclasses[subclass] = append(subclasses[subclass], parent)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %w", filename, err)
+	}
+
+	return nil
+}
+
+// broaderDfs returns all MIME types the given MIME type is a subclass of, directly or indirectly,
+// using a depth-first search. The MIME type itself is not included.
+// E.g. for application/x-shellscript, this is application/x-executable, text/plain, ...
+// The implicit subclasses of the spec come last: text/* types are subclasses of text/plain and
+// all streams, i.e. types other than inode/* and x-scheme-handler/*, of application/octet-stream.
+func (info *MimeInfo) broaderDfs(mimeType string) []string {
+	var result []string
+	visited := map[string]bool{strings.ToLower(mimeType): true}
+
+	var visit func(mime string)
+	visit = func(mime string) {
+		for _, parent := range info.Subclasses[strings.ToLower(mime)] {
+			if visited[strings.ToLower(parent)] {
+				continue
+			}
+
+			visited[strings.ToLower(parent)] = true
+			result = append(result, parent)
+			visit(parent)
+		}
+	}
+	visit(mimeType)
+
+	lowerMime := strings.ToLower(mimeType)
+	if strings.HasPrefix(lowerMime, "text/") && !visited["text/plain"] {
+		visited["text/plain"] = true
+		result = append(result, "text/plain")
+	}
+
+	if !strings.HasPrefix(lowerMime, "inode/") &&
+		!strings.HasPrefix(lowerMime, "x-scheme-handler/") &&
+		!visited["application/octet-stream"] {
+		result = append(result, "application/octet-stream")
+	}
+
+	return result
+}
+
+// loadMimeTypes loads the types files of the shared-mime-info database. The result maps the
+// lowercase MIME types to their spelling in the database. Returns false if no types file exists.
+func loadMimeTypes() (map[string]string, bool, error) {
+	result := make(map[string]string)
+	found := false
+	for _, dir := range getMimeDirs() {
+		file, err := os.Open(path.Join(dir, "types"))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		case err != nil:
+			return nil, false, err
+		}
+
+		found = true
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			lowerLine := strings.ToLower(line)
+			if _, exists := result[lowerLine]; line != "" && !exists {
+				result[lowerLine] = line
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("error reading %s: %w", file.Name(), err)
+		}
+	}
+
+	return result, found, nil
+}
@@ -0,0 +1,123 @@
+package opnlib
+
+import (
+	"github.com/MatthiasKunnen/xdg/basedir"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// useMimeFixture replaces the shared-mime-info database with the given files for the duration of
+// the test.
+func useMimeFixture(t *testing.T, files map[string]string) {
+	t.Helper()
+
+	dataHome := t.TempDir()
+	mimeDir := filepath.Join(dataHome, "mime")
+	if err := os.Mkdir(mimeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(mimeDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldDataHome, oldDataDirs := basedir.DataHome, basedir.DataDirs
+	basedir.DataHome, basedir.DataDirs = dataHome, nil
+	t.Cleanup(func() {
+		basedir.DataHome, basedir.DataDirs = oldDataHome, oldDataDirs
+	})
+}
+
+func TestGetDesktopIdsForBroadMimeCached(t *testing.T) {
+	useMimeFixture(t, map[string]string{
+		"types": "application/octet-stream\napplication/pdf\napplication/x-executable\n" +
+			"application/x-shellscript\ninode/directory\ntext/markdown\ntext/plain\ntext/x-Mixed\n",
+		"aliases": "application/x-pdf application/pdf\n",
+		"subclasses": "application/x-shellscript application/x-executable\n" +
+			"application/x-shellscript text/plain\n",
+	})
+
+	uncached := &Opn{SkipCache: true}
+	if err := uncached.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	cachePath := filepath.Join(t.TempDir(), "db.bin")
+	generated := &Opn{CacheFilePath: cachePath, SkipDaemon: true}
+	if err := generated.LoadAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	cached := &Opn{CacheFilePath: cachePath, SkipDaemon: true}
+	if err := cached.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if cached.index.lazy == nil {
+		t.Fatal("expected the index to be loaded from the cache")
+	}
+
+	tests := []struct {
+		mime     string
+		expected []string
+	}{
+		{
+			mime: "application/x-shellscript",
+			expected: []string{
+				"application/x-shellscript",
+				"application/x-executable",
+				"text/plain",
+				"application/octet-stream",
+			},
+		},
+		{
+			mime:     "Application/X-PDF",
+			expected: []string{"application/pdf", "application/octet-stream"},
+		},
+		{
+			mime:     "text/markdown",
+			expected: []string{"text/markdown", "text/plain", "application/octet-stream"},
+		},
+		{
+			mime:     "TEXT/X-MIXED",
+			expected: []string{"text/x-Mixed", "text/plain", "application/octet-stream"},
+		},
+		{
+			mime:     "text/plain",
+			expected: []string{"text/plain", "application/octet-stream"},
+		},
+		{
+			mime:     "application/octet-stream",
+			expected: []string{"application/octet-stream"},
+		},
+		{
+			mime:     "inode/directory",
+			expected: []string{"inode/directory"},
+		},
+		{
+			mime:     "x-scheme-handler/https",
+			expected: []string{"x-scheme-handler/https"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mime, func(t *testing.T) {
+			uncachedResult := uncached.GetDesktopIdsForBroadMime(test.mime)
+			cachedResult := cached.GetDesktopIdsForBroadMime(test.mime)
+			if !reflect.DeepEqual(uncachedResult, cachedResult) {
+				t.Errorf("uncached result %v differs from cached %v", uncachedResult, cachedResult)
+			}
+
+			mimes := make([]string, 0, len(cachedResult))
+			for _, item := range cachedResult {
+				mimes = append(mimes, item.Mime)
+			}
+			if !reflect.DeepEqual(mimes, test.expected) {
+				t.Errorf("expected MIME types %v, got %v", test.expected, mimes)
+			}
+		})
+	}
+}
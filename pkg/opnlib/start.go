@@ -0,0 +1,142 @@
+package opnlib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// daemonStartDetached is the method that makes the daemon start a program, see
+// Opn.StartDetached.
+const daemonStartDetached = "StartDetached"
+
+// StartDetached starts the program in a new session and returns once it has been started. env is
+// the complete environment of the program. The program is started in dir, or in the working
+// directory of this process if dir is empty.
+// If the daemon is used, the daemon starts the program. The program then keeps running when the
+// terminal this process runs in closes, even if the terminal stops every process it started.
+// Otherwise, or if the daemon cannot be reached, the program is started by this process.
+func (opn *Opn) StartDetached(dir string, env []string, arguments []string) error {
+	if len(arguments) == 0 {
+		return errors.New("no program to start")
+	}
+
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to determine the working directory: %w", err)
+		}
+		dir = wd
+	}
+
+	// The program is looked up using the PATH and working directory of this process, not the ones
+	// of the daemon
+	program, err := exec.LookPath(arguments[0])
+	if err != nil {
+		return err
+	}
+
+	program, err = filepath.Abs(program)
+	if err != nil {
+		return err
+	}
+
+	if opn.daemon != nil {
+		// A new connection is used as the daemon might have closed the idle connection. Once the
+		// request is sent, the program must not be started again if the response is lost.
+		client, err := dialDaemon(GetDaemonSocketPath(), opn.GetCachePath())
+		if err == nil {
+			defer client.close()
+			args := encodeStartArgs(program, dir, env, arguments)
+			response, err := client.call(daemonStartDetached, args)
+			switch {
+			case err != nil:
+				return fmt.Errorf("failed to receive the response of the daemon: %w", err)
+			case response.Error != "":
+				return errors.New(response.Error)
+			default:
+				return nil
+			}
+		}
+	}
+
+	cmd, err := startDetached(program, dir, env, arguments)
+	if err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
+
+// startDetached starts the program in a new session. program is the absolute path of the
+// executable.
+func startDetached(
+	program string,
+	dir string,
+	env []string,
+	arguments []string,
+) (*exec.Cmd, error) {
+	cmd := &exec.Cmd{
+		Path: program,
+		Args: arguments,
+		Env:  env,
+		Dir:  dir,
+		SysProcAttr: &syscall.SysProcAttr{
+			Setsid: true, // Start new session
+		},
+	}
+
+	return cmd, cmd.Start()
+}
+
+// encodeStartArgs returns the arguments of the daemonStartDetached request.
+func encodeStartArgs(program string, dir string, env []string, arguments []string) []string {
+	args := make([]string, 0, 3+len(env)+len(arguments))
+	args = append(args, program, dir, strconv.Itoa(len(env)))
+	args = append(args, env...)
+
+	return append(args, arguments...)
+}
+
+// decodeStartArgs is the inverse of encodeStartArgs.
+func decodeStartArgs(args []string) (string, string, []string, []string, error) {
+	if len(args) < 3 {
+		return "", "", nil, nil, fmt.Errorf("expected at least 3 arguments, got %d", len(args))
+	}
+
+	envCount, err := strconv.Atoi(args[2])
+	if err != nil || envCount < 0 || len(args)-3-envCount < 1 {
+		return "", "", nil, nil, fmt.Errorf("invalid environment size %s", args[2])
+	}
+
+	env := args[3 : 3+envCount]
+	return args[0], args[1], env, args[3+envCount:], nil
+}
+
+// startDetachedByDaemon starts the program of a daemonStartDetached request. The daemon waits for
+// the program to exit so that it does not remain a zombie.
+func startDetachedByDaemon(args []string) error {
+	program, dir, env, arguments, err := decodeStartArgs(args)
+	if err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+
+	if !filepath.IsAbs(program) || !filepath.IsAbs(dir) {
+		return fmt.Errorf("invalid request: %s and %s must be absolute", program, dir)
+	}
+
+	cmd, err := startDetached(program, dir, env, arguments)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		_ = cmd.Wait()
+	}()
+
+	return nil
+}
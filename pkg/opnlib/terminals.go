@@ -0,0 +1,159 @@
+package opnlib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/MatthiasKunnen/xdg/basedir"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// Keys of the terminal's desktop entry that describe how to pass options to the terminal, see
+// https://gitlab.freedesktop.org/terminal-wg/specifications/-/merge_requests/3.
+const (
+	TerminalArgExec  = "X-TerminalArgExec"
+	TerminalArgAppId = "X-TerminalArgAppId"
+	TerminalArgTitle = "X-TerminalArgTitle"
+	TerminalArgDir   = "X-TerminalArgDir"
+)
+
+// GetXdgTerminalsListPaths returns the paths where xdg-terminals.list files can be located, in
+// order of highest to lowest priority. The files do not necessarily exist.
+func GetXdgTerminalsListPaths(currentDesktop string) []string {
+	var desktops []string
+	for _, d := range strings.Split(currentDesktop, ":") {
+		if d != "" {
+			desktops = append(desktops, strings.ToLower(d))
+		}
+	}
+
+	var dirs []string
+	dirs = append(dirs, basedir.ConfigHome)
+	dirs = append(dirs, basedir.ConfigDirs...)
+	if basedir.DataHome != "" {
+		dirs = append(dirs, path.Join(basedir.DataHome, "xdg-terminal-exec"))
+	}
+	for _, dataDir := range basedir.DataDirs {
+		dirs = append(dirs, path.Join(dataDir, "xdg-terminal-exec"))
+	}
+
+	result := make([]string, 0, len(dirs)*(len(desktops)+1))
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+
+		for _, d := range desktops {
+			result = append(result, path.Join(dir, d+"-xdg-terminals.list"))
+		}
+		result = append(result, path.Join(dir, "xdg-terminals.list"))
+	}
+
+	return result
+}
+
+// GetPreferredTerminals returns the desktop IDs of the preferred terminals, in order of highest to
+// lowest priority, as listed in the xdg-terminals.list files.
+// An entry prefixed with - excludes the desktop ID from the lists with a lower priority.
+// Actions, the part after the colon, are not supported and are ignored.
+func GetPreferredTerminals(currentDesktop string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+	for _, listPath := range GetXdgTerminalsListPaths(currentDesktop) {
+		entries, err := parseXdgTerminalsList(listPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			desktopId, isExcluded := strings.CutPrefix(entry, "-")
+			desktopId = strings.TrimPrefix(desktopId, "+")
+			desktopId, _, _ = strings.Cut(desktopId, ":")
+			if seen[desktopId] {
+				continue
+			}
+
+			seen[desktopId] = true
+			if !isExcluded {
+				result = append(result, desktopId)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// parseXdgTerminalsList returns the entries of the xdg-terminals.list file.
+func parseXdgTerminalsList(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		result = append(result, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", filename, err)
+	}
+
+	return result, nil
+}
+
+// GetTerminalArgs returns the X-TerminalArg* keys of the desktop file, e.g. TerminalArgExec.
+// Keys that are not present are absent from the map, keys with an empty value are included.
+func GetTerminalArgs(desktopFilePath string) (map[string]string, error) {
+	file, err := os.Open(desktopFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := make(map[string]string)
+	inDesktopEntry := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			inDesktopEntry = line == "[Desktop Entry]"
+			continue
+		}
+
+		if !inDesktopEntry {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || !strings.HasPrefix(key, "X-TerminalArg") {
+			continue
+		}
+
+		result[key] = strings.TrimSpace(value)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", desktopFilePath, err)
+	}
+
+	return result, nil
+}